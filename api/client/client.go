@@ -0,0 +1,150 @@
+// Package client abstracts torrent clients (Transmission, qBittorrent) behind one interface
+package client
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"torrentino/common/ordmap"
+)
+
+// normalized torrent statuses, the same for every client
+const (
+	STATUS_STOPPED       = "stopped"
+	STATUS_CHECK_WAIT    = "waiting to check files"
+	STATUS_CHECK         = "checking files"
+	STATUS_DOWNLOAD_WAIT = "waiting to download"
+	STATUS_DOWNLOADING   = "downloading"
+	STATUS_SEED_WAIT     = "waiting to seed"
+	STATUS_SEEDING       = "seeding"
+	STATUS_ISOLATED      = "can't find peers"
+	STATUS_ERROR         = "error"
+	STATUS_UNKNOWN       = "unknown"
+)
+
+// file priorities
+const (
+	PRIORITY_LOW    = -1
+	PRIORITY_NORMAL = 0
+	PRIORITY_HIGH   = 1
+)
+
+type File struct {
+	Name           string
+	Length         int64
+	BytesCompleted int64
+	Wanted         bool
+	Priority       int // PRIORITY_*
+}
+
+type Torrent struct {
+	Hash               string // identifies the torrent in any client
+	Name               string
+	Status             string
+	Error              string
+	PercentDone        float64
+	DownloadedEver     int64
+	UploadedEver       int64
+	UploadRatio        float64
+	TotalSize          int64
+	PeersGettingFromUs int64
+	PeersSendingToUs   int64
+	AddedDate          time.Time
+	DoneDate           time.Time
+	DownloadDir        string
+	Category           string
+	Files              []File
+}
+
+type AddOptions struct {
+	DownloadDir    string
+	Category       string   // qBittorrent category, Transmission label unless Labels are set
+	Labels         []string // Transmission labels
+	BandwidthGroup string   // Transmission bandwidth group
+	Paused         bool
+}
+
+// DownloadClient calls are bound to ctx, the implementations add their own per-call deadlines
+type DownloadClient interface {
+	List(ctx context.Context) ([]Torrent, error)
+	Add(ctx context.Context, urlOrMagnet string, options AddOptions) (Torrent, error)
+	Start(ctx context.Context, hash string) error
+	Pause(ctx context.Context, hash string) error
+	Delete(ctx context.Context, hash string, deleteData bool) error
+	Files(ctx context.Context, hash string) ([]File, error)
+	SetWanted(ctx context.Context, hash string, files []int, wanted bool) error    // files are indexes in Files() result
+	SetPriority(ctx context.Context, hash string, files []int, priority int) error // see File.Priority
+	Move(ctx context.Context, hash string, dir string) error                       // relocates the data, the torrent keeps seeding from there
+}
+
+// Stats is the state of the whole daemon
+type Stats struct {
+	Version       string
+	Torrents      int64
+	Active        int64
+	DownloadSpeed int64 // bytes per second
+	UploadSpeed   int64
+}
+
+// Reporter is implemented by the clients which can tell their Stats, the others are only pinged with List
+type Reporter interface {
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// ErrUnsupported is returned for the options the client doesn't have, e.g. low priority of qBittorrent
+var ErrUnsupported = errors.New("not supported by the client")
+
+// Prioritizer is implemented by the clients which don't have all the PRIORITY_* levels
+type Prioritizer interface {
+	Priorities() []int
+}
+
+// Priorities returns the PRIORITY_* levels of the client, from the highest one
+func Priorities(c DownloadClient) []int {
+	if p, ok := c.(Prioritizer); ok {
+		return p.Priorities()
+	}
+	return []int{PRIORITY_HIGH, PRIORITY_NORMAL, PRIORITY_LOW}
+}
+
+// Clients are the named instances from "download-clients" setting, in the settings order
+type Clients struct {
+	*ordmap.OrderedMap[string, DownloadClient]
+}
+
+func NewClients() *Clients {
+	return &Clients{ordmap.New[string, DownloadClient]()}
+}
+
+// Default is the first instance, the target of downloads when there is no choice
+func (c *Clients) Default() (name string, dc DownloadClient) {
+	for name, dc = range c.Iter() {
+		break
+	}
+	return name, dc
+}
+
+// MagnetHash extracts info hash from magnet link in lowercase hex, as the clients report it,
+// empty string for anything else
+func MagnetHash(urlOrMagnet string) string {
+	u, err := url.Parse(urlOrMagnet)
+	if err != nil || u.Scheme != "magnet" {
+		return ""
+	}
+	for _, xt := range u.Query()["xt"] {
+		if hash, ok := strings.CutPrefix(xt, "urn:btih:"); ok {
+			if len(hash) == 32 { // base32 form of the 20 bytes
+				if data, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+					return hex.EncodeToString(data)
+				}
+			}
+			return strings.ToLower(hash)
+		}
+	}
+	return ""
+}
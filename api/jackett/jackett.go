@@ -2,10 +2,9 @@ package jackett
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -15,9 +14,10 @@ import (
 
 	gotorrentparser "github.com/j-muller/go-torrent-parser"
 	"github.com/pkg/errors"
+	"github.com/zeebo/bencode"
 
+	"torrentino/api/resilience"
 	"torrentino/common"
-	"torrentino/common/utils"
 )
 
 type jackettTime struct {
@@ -97,43 +97,65 @@ type QueryResults struct {
 	Indexers []Indexer
 }
 
-var apiKey string
-var baseUrl string
-var client *http.Client
-
-func httpGet(url string, timeout time.Duration) (*[]byte, error) {
-	var res *http.Response
-	err := utils.WithTimeout(
-		func() error {
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				return err
-			}
-			res, err = client.Do(req)
-			if err != nil {
-				return err
-			}
-			if res != nil && res.StatusCode != 200 {
-				return fmt.Errorf("Request error: %s", res.Status)
-			}
-			return err
-		},
-		timeout,
-	)
+// DEFAULT_PORT is used when the settings have none
+const DEFAULT_PORT = 9117
+
+// Client talks to one Jackett instance
+type Client struct {
+	apiKey  string
+	baseUrl string
+	http    *http.Client
+	backend *resilience.Backend
+}
+
+// NewClient builds the client of the Jackett at cfg host and port
+func NewClient(cfg common.Jackett) (*Client, error) {
+	jar, err := cookiejar.New(nil)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "cookie jar")
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = DEFAULT_PORT
 	}
-	defer res.Body.Close()
-	data, err := io.ReadAll(res.Body)
+	return &Client{
+		apiKey:  cfg.APIKey,
+		baseUrl: "http://" + cfg.Host + ":" + strconv.Itoa(port) + "/api/v2.0/",
+		http:    &http.Client{Jar: jar},
+		backend: resilience.New("Jackett"),
+	}, nil
+}
+
+// httpGet retries the request within the timeout of every attempt, all the calls of Jackett are reads
+func (c *Client) httpGet(ctx context.Context, url string, timeout time.Duration) (*[]byte, error) {
+	var data []byte
+	err := c.backend.Do(ctx, true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return resilience.StatusError(res)
+		}
+		data, err = io.ReadAll(res.Body)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &data, nil
 }
 
-func GetValidIndexers() (*[]Indexer, error) {
+func (c *Client) GetValidIndexers(ctx context.Context) (*[]Indexer, error) {
 	var r []Indexer
-	data, err := httpGet("indexers?Configured=true", 30*time.Second)
+	data, err := c.httpGet(ctx, c.baseUrl+"indexers?Configured=true&apikey="+c.apiKey, 30*time.Second)
 	if err != nil {
 		return nil, errors.Wrap(err, "GetValidIndexers")
 	}
@@ -144,26 +166,78 @@ func GetValidIndexers() (*[]Indexer, error) {
 	return &r, nil
 }
 
-func GetInfoHash(url string) (string, error) {
-	res, err := httpGet(url, 5*time.Second)
+// Probe runs the empty search (the latest releases) on one indexer, as Jackett's own test does.
+// Jackett replies 200 when the indexer fails, the failure is in the Indexers of the results
+func (c *Client) Probe(ctx context.Context, indexer string) error {
+	data, err := c.httpGet(ctx, c.baseUrl+"indexers/"+url.PathEscape(indexer)+"/results?apikey="+c.apiKey+"&Query=", 30*time.Second)
 	if err != nil {
-		return "", err
+		return errors.Wrap(err, indexer)
+	}
+	var r QueryResults
+	if err = json.Unmarshal(*data, &r); err != nil {
+		return errors.Wrap(err, indexer)
+	}
+	for _, i := range r.Indexers {
+		if i.ID == indexer && i.Error != "" {
+			return errors.New(i.Error)
+		}
+	}
+	return nil
+}
+
+// TorrentInfo adds the fields gotorrentparser doesn't expose
+type TorrentInfo struct {
+	*gotorrentparser.Torrent
+	PieceLength int64
+	Private     bool
+}
+
+// GetTorrent downloads and parses the .torrent file of a result
+func (c *Client) GetTorrent(ctx context.Context, url string) (*gotorrentparser.Torrent, error) {
+	info, err := c.GetTorrentInfo(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return info.Torrent, nil
+}
+
+func (c *Client) GetTorrentInfo(ctx context.Context, url string) (*TorrentInfo, error) {
+	res, err := c.httpGet(ctx, url, 5*time.Second)
+	if err != nil {
+		return nil, err
 	}
 	torrent, err := gotorrentparser.Parse(bytes.NewReader(*res))
+	if err != nil {
+		return nil, err
+	}
+	var meta struct {
+		Info struct {
+			PieceLength int64 `bencode:"piece length"`
+			Private     int   `bencode:"private"`
+		} `bencode:"info"`
+	}
+	if err = bencode.DecodeBytes(*res, &meta); err != nil {
+		return nil, errors.Wrap(err, "GetTorrentInfo")
+	}
+	return &TorrentInfo{torrent, meta.Info.PieceLength, meta.Info.Private == 1}, nil
+}
+
+func (c *Client) GetInfoHash(ctx context.Context, url string) (string, error) {
+	torrent, err := c.GetTorrent(ctx, url)
 	if err != nil {
 		return "", err
 	}
 	return torrent.InfoHash, nil
 }
 
-func Query(str string, indexers []string) (*[]Result, error) {
+func (c *Client) Query(ctx context.Context, str string, indexers []string) (*[]Result, error) {
 
-	var u = "indexers/status:healthy,test:passed/results?apikey=" + apiKey
+	var u = "indexers/status:healthy,test:passed/results?apikey=" + c.apiKey
 	for _, indexer := range indexers {
 		u = u + "&Tracker[]=" + indexer
 	}
 	u = u + "&Query=" + url.QueryEscape(str)
-	data, err := httpGet(baseUrl+u, 30*time.Second)
+	data, err := c.httpGet(ctx, c.baseUrl+u, 30*time.Second)
 	if err != nil {
 		return nil, errors.Wrap(err, "Jackett")
 	}
@@ -175,16 +249,3 @@ func Query(str string, indexers []string) (*[]Result, error) {
 	}
 	return &r.Results, nil
 }
-
-func init() {
-	var jkt = &common.Settings.Jackett
-	apiKey = jkt.APIKey
-	baseUrl = "http://" + jkt.Host + ":" + strconv.Itoa(jkt.Port) + "/api/v2.0/"
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		log.Fatalf("error creating cookie jar %s", err.Error())
-	}
-	client = &http.Client{
-		Jar: jar,
-	}
-}
@@ -0,0 +1,105 @@
+package jackett_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"torrentino/api/jackett"
+	"torrentino/api/resilience"
+	"torrentino/common"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+)
+
+func TestQuery(t *testing.T) {
+	b := backends.Start(t)
+	b.Jackett.AddResults(
+		jackett.Result{Title: "Ubuntu 24.04 Desktop", TrackerId: "rutor", Size: 6 << 30},
+		jackett.Result{Title: "Ubuntu 24.04 Server", TrackerId: "kinozal"},
+		jackett.Result{Title: "Debian 12", TrackerId: "rutor"},
+	)
+
+	results, err := b.JackettClient.Query(t.Context(), "ubuntu", []string{"rutor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*results) != 1 || (*results)[0].Title != "Ubuntu 24.04 Desktop" {
+		t.Errorf("unexpected results %v", *results)
+	}
+	if q := b.Jackett.Queries(); len(q) != 1 || q[0].Get("Query") != "ubuntu" {
+		t.Errorf("unexpected queries %v", q)
+	}
+}
+
+func TestIndexers(t *testing.T) {
+	b := backends.Start(t)
+	b.Jackett.AddIndexers(
+		jackett.Indexer{ID: "rutor", Name: "Rutor", Configured: true},
+		jackett.Indexer{ID: "kinozal", Name: "Kinozal", Configured: true, Error: "login failed"},
+		jackett.Indexer{ID: "idle", Name: "Idle"},
+	)
+
+	indexers, err := b.JackettClient.GetValidIndexers(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*indexers) != 2 || (*indexers)[0].ID != "rutor" {
+		t.Errorf("unexpected indexers %v", *indexers)
+	}
+	if err = b.JackettClient.Probe(t.Context(), "rutor"); err != nil {
+		t.Error(err)
+	}
+	if err = b.JackettClient.Probe(t.Context(), "kinozal"); err == nil || err.Error() != "login failed" {
+		t.Errorf("expected the indexer error, got %v", err)
+	}
+}
+
+func TestQueryServerError(t *testing.T) {
+	b := backends.Start(t)
+	b.Jackett.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1})
+	if _, err := b.JackettClient.Query(t.Context(), "ubuntu", nil); err != nil || b.Jackett.Hits() != 2 {
+		t.Errorf("expected recovery on retry, got %v after %d requests", err, b.Jackett.Hits())
+	}
+
+	b.Jackett.Inject(fault.Fault{Status: http.StatusInternalServerError})
+	_, err := b.JackettClient.Query(t.Context(), "ubuntu", nil)
+	if resilience.Message(err) != "Jackett unavailable, retrying in 30 s" {
+		t.Errorf("unexpected error %v", err)
+	}
+	hits := b.Jackett.Hits()
+	b.Jackett.Clear()
+	if _, err = b.JackettClient.Query(t.Context(), "ubuntu", nil); err == nil || b.Jackett.Hits() != hits {
+		t.Errorf("open circuit must not let requests through, got %v", err)
+	}
+}
+
+func TestQueryCanceled(t *testing.T) {
+	b := backends.Start(t)
+	b.Jackett.Inject(fault.Fault{Delay: 10 * time.Second, Times: 1})
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := b.JackettClient.Query(ctx, "ubuntu", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline of the caller, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("the request outlived the context")
+	}
+}
+
+func TestDefaultPort(t *testing.T) {
+	c, err := jackett.NewClient(common.Jackett{HostPort: common.HostPort{Host: "jackett"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel() // the request is not sent, its url is seen in the error
+	if _, err = c.Query(ctx, "ubuntu", nil); err == nil || !strings.Contains(err.Error(), "http://jackett:9117/") {
+		t.Errorf("expected the request to the default port, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package qbittorrent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"torrentino/api/client"
+	"torrentino/api/resilience"
+)
+
+// Client implements client.DownloadClient over qBittorrent WebUI API v2
+type Client struct {
+	baseUrl  string
+	username string
+	password string
+	http     *http.Client
+	backend  *resilience.Backend
+
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+const (
+	DEFAULT_PORT = 8080
+	TIMEOUT      = 30 * time.Second // of every attempt, including the login
+)
+
+// New connects to WebUI at endpoint like "https://seedbox.example.com/qbittorrent",
+// tlsConfig (e.g. with a private CA) may be nil
+func New(endpoint string, username string, password string, tlsConfig *tls.Config) (*Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "qBittorrent")
+	}
+	httpClient := &http.Client{Jar: jar}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient.Transport = transport
+	}
+	return &Client{
+		baseUrl:  strings.TrimRight(endpoint, "/") + "/api/v2/",
+		username: username,
+		password: password,
+		http:     httpClient,
+		backend:  resilience.New("qBittorrent"),
+	}, nil
+}
+
+var errForbidden = errors.New("forbidden")
+
+// send posts the form, or gets the url when params are nil
+func (c *Client) send(ctx context.Context, method string, params url.Values) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	if params == nil {
+		req, err = http.NewRequestWithContext(ctx, "GET", c.baseUrl+method, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "POST", c.baseUrl+method, strings.NewReader(params.Encode()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if params != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return c.http.Do(req)
+}
+
+func (c *Client) login(ctx context.Context) error {
+	res, err := c.send(ctx, "auth/login", url.Values{
+		"username": {c.username},
+		"password": {c.password},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return errors.Wrap(resilience.StatusError(res), "login failed")
+	}
+	if strings.TrimSpace(string(body)) != "Ok." {
+		return fmt.Errorf("login failed: %s", body)
+	}
+	c.loggedIn = true
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method string, params url.Values) ([]byte, error) {
+	res, err := c.send(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusForbidden:
+		return nil, errForbidden
+	case http.StatusNotFound:
+		return nil, errors.Wrap(errNotFound, method)
+	}
+	return nil, errors.Wrap(resilience.StatusError(res), method)
+}
+
+var errNotFound = errors.New("not found")
+
+// request calls api method through the circuit breaker, logging in first and once again
+// if the session has expired, idempotent calls are retried
+func (c *Client) request(ctx context.Context, idempotent bool, method string, params url.Values) ([]byte, error) {
+	var data []byte
+	err := c.backend.Do(ctx, idempotent, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
+		defer cancel()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !c.loggedIn {
+			if err := c.login(ctx); err != nil {
+				return errors.Wrap(err, "qBittorrent")
+			}
+		}
+		var err error
+		data, err = c.do(ctx, method, params)
+		if err == errForbidden {
+			c.loggedIn = false
+			if err = c.login(ctx); err != nil {
+				return errors.Wrap(err, "qBittorrent")
+			}
+			data, err = c.do(ctx, method, params)
+		}
+		return errors.Wrap(err, "qBittorrent")
+	})
+	return data, err
+}
+
+// request the first of alternative methods which exists, as WebUI API v5 renamed some of them
+func (c *Client) requestAny(ctx context.Context, idempotent bool, methods []string, params url.Values) (err error) {
+	for _, method := range methods {
+		if _, err = c.request(ctx, idempotent, method, params); !errors.Is(err, errNotFound) {
+			return err
+		}
+	}
+	return err
+}
+
+type torrentInfo struct {
+	Hash         string  `json:"hash"`
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Progress     float64 `json:"progress"`
+	Downloaded   int64   `json:"downloaded"`
+	Uploaded     int64   `json:"uploaded"`
+	Ratio        float64 `json:"ratio"`
+	TotalSize    int64   `json:"total_size"`
+	NumSeeds     int64   `json:"num_seeds"`
+	NumLeechs    int64   `json:"num_leechs"`
+	AddedOn      int64   `json:"added_on"`
+	CompletionOn int64   `json:"completion_on"`
+	SavePath     string  `json:"save_path"`
+	Category     string  `json:"category"`
+}
+
+type fileInfo struct {
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Progress float64 `json:"progress"`
+	Priority int     `json:"priority"` // PRIO_*
+}
+
+// file priorities of WebUI API, there is no "low" one
+const (
+	PRIO_SKIP   = 0
+	PRIO_NORMAL = 1
+	PRIO_HIGH   = 6
+	PRIO_MAX    = 7
+)
+
+func status(state string) string {
+	switch state {
+	case "downloading", "forcedDL", "metaDL", "forcedMetaDL":
+		return client.STATUS_DOWNLOADING
+	case "stalledDL":
+		return client.STATUS_ISOLATED
+	case "uploading", "forcedUP", "stalledUP":
+		return client.STATUS_SEEDING
+	case "pausedDL", "pausedUP", "stoppedDL", "stoppedUP":
+		return client.STATUS_STOPPED
+	case "queuedDL", "allocating":
+		return client.STATUS_DOWNLOAD_WAIT
+	case "queuedUP":
+		return client.STATUS_SEED_WAIT
+	case "checkingDL", "checkingUP", "checkingResumeData", "moving":
+		return client.STATUS_CHECK
+	case "error", "missingFiles":
+		return client.STATUS_ERROR
+	}
+	return client.STATUS_UNKNOWN
+}
+
+func (t *torrentInfo) convert() client.Torrent {
+	result := client.Torrent{
+		Hash:               t.Hash,
+		Name:               t.Name,
+		Status:             status(t.State),
+		PercentDone:        t.Progress,
+		DownloadedEver:     t.Downloaded,
+		UploadedEver:       t.Uploaded,
+		UploadRatio:        t.Ratio,
+		TotalSize:          t.TotalSize,
+		PeersGettingFromUs: t.NumLeechs,
+		PeersSendingToUs:   t.NumSeeds,
+		AddedDate:          time.Unix(t.AddedOn, 0),
+		DownloadDir:        t.SavePath,
+		Category:           t.Category,
+	}
+	if t.CompletionOn > 0 {
+		result.DoneDate = time.Unix(t.CompletionOn, 0)
+	}
+	if result.Status == client.STATUS_ERROR {
+		result.Error = t.State
+	}
+	return result
+}
+
+func (f *fileInfo) convert() client.File {
+	file := client.File{
+		Name:           f.Name,
+		Length:         f.Size,
+		BytesCompleted: int64(f.Progress * float64(f.Size)),
+		Wanted:         f.Priority != 0,
+	}
+	if f.Priority > PRIO_NORMAL {
+		file.Priority = client.PRIORITY_HIGH
+	}
+	return file
+}
+
+func (c *Client) List(ctx context.Context) ([]client.Torrent, error) {
+	data, err := c.request(ctx, true, "torrents/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	var torrents []torrentInfo
+	if err = json.Unmarshal(data, &torrents); err != nil {
+		return nil, errors.Wrap(err, "qBittorrent")
+	}
+	result := make([]client.Torrent, len(torrents))
+	for i := range torrents {
+		result[i] = torrents[i].convert()
+	}
+	return result, nil
+}
+
+func (c *Client) Add(ctx context.Context, urlOrMagnet string, options client.AddOptions) (client.Torrent, error) {
+	params := url.Values{"urls": {urlOrMagnet}}
+	if options.DownloadDir != "" {
+		params.Set("savepath", options.DownloadDir)
+	}
+	if options.Category != "" {
+		params.Set("category", options.Category)
+	}
+	if options.Paused {
+		params.Set("paused", "true")  // WebUI API v2.x
+		params.Set("stopped", "true") // WebUI API v5
+	}
+	data, err := c.request(ctx, false, "torrents/add", params)
+	if err != nil {
+		return client.Torrent{}, err
+	}
+	if strings.TrimSpace(string(data)) == "Fails." {
+		return client.Torrent{}, errors.New("qBittorrent: torrent is not added")
+	}
+	return client.Torrent{
+		Hash:        client.MagnetHash(urlOrMagnet), // unknown for .torrent links until the next List()
+		DownloadDir: options.DownloadDir,
+		Category:    options.Category,
+	}, nil
+}
+
+func (c *Client) Start(ctx context.Context, hash string) error {
+	return c.requestAny(ctx, true, []string{"torrents/resume", "torrents/start"}, url.Values{"hashes": {hash}})
+}
+
+func (c *Client) Pause(ctx context.Context, hash string) error {
+	return c.requestAny(ctx, true, []string{"torrents/pause", "torrents/stop"}, url.Values{"hashes": {hash}})
+}
+
+func (c *Client) Move(ctx context.Context, hash string, dir string) error {
+	_, err := c.request(ctx, false, "torrents/setLocation", url.Values{"hashes": {hash}, "location": {dir}})
+	return err
+}
+
+func (c *Client) Delete(ctx context.Context, hash string, deleteData bool) error {
+	_, err := c.request(ctx, false, "torrents/delete", url.Values{
+		"hashes":      {hash},
+		"deleteFiles": {strconv.FormatBool(deleteData)},
+	})
+	return err
+}
+
+func (c *Client) Files(ctx context.Context, hash string) ([]client.File, error) {
+	data, err := c.request(ctx, true, "torrents/files?hash="+url.QueryEscape(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+	var files []fileInfo
+	if err = json.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrap(err, "qBittorrent")
+	}
+	result := make([]client.File, len(files))
+	for i := range files {
+		result[i] = files[i].convert()
+	}
+	return result, nil
+}
+
+func (c *Client) setFilePriority(ctx context.Context, hash string, files []int, priority int) error {
+	ids := make([]string, len(files))
+	for i, f := range files {
+		ids[i] = strconv.Itoa(f)
+	}
+	_, err := c.request(ctx, true, "torrents/filePrio", url.Values{
+		"hash":     {hash},
+		"id":       {strings.Join(ids, "|")},
+		"priority": {strconv.Itoa(priority)},
+	})
+	return err
+}
+
+func (c *Client) SetWanted(ctx context.Context, hash string, files []int, wanted bool) error {
+	if wanted {
+		return c.setFilePriority(ctx, hash, files, PRIO_NORMAL)
+	}
+	return c.setFilePriority(ctx, hash, files, PRIO_SKIP)
+}
+
+// SetPriority makes the files wanted as well, as priority is the only wanted flag in qBittorrent
+func (c *Client) SetPriority(ctx context.Context, hash string, files []int, priority int) error {
+	switch priority {
+	case client.PRIORITY_HIGH:
+		return c.setFilePriority(ctx, hash, files, PRIO_HIGH)
+	case client.PRIORITY_NORMAL:
+		return c.setFilePriority(ctx, hash, files, PRIO_NORMAL)
+	}
+	return errors.Wrap(client.ErrUnsupported, "qBittorrent priority "+strconv.Itoa(priority))
+}
+
+// Priorities implements client.Prioritizer, qBittorrent has no priority below normal but "skip"
+func (c *Client) Priorities() []int {
+	return []int{client.PRIORITY_HIGH, client.PRIORITY_NORMAL}
+}
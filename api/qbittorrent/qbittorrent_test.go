@@ -0,0 +1,164 @@
+package qbittorrent_test
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"torrentino/api/client"
+	"torrentino/api/qbittorrent"
+	"torrentino/api/resilience"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+	fakeqbittorrent "torrentino/fakes/qbittorrent"
+)
+
+func start(t *testing.T) (*fakeqbittorrent.Server, *qbittorrent.Client) {
+	backends.FastRetries(t)
+	s := fakeqbittorrent.NewServer()
+	t.Cleanup(s.Close)
+	c, err := qbittorrent.New(s.URL, fakeqbittorrent.USERNAME, fakeqbittorrent.PASSWORD, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func TestLifecycle(t *testing.T) {
+	for _, v5 := range []bool{false, true} {
+		s, c := start(t)
+		s.V5 = v5
+
+		torrent, err := c.Add(t.Context(), "magnet:?xt=urn:btih:ABCDEF&dn=ubuntu", client.AddOptions{DownloadDir: "/downloads/series", Category: "series"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if torrent.Hash != "abcdef" {
+			t.Errorf("unexpected hash %s", torrent.Hash)
+		}
+
+		s.Logout() // the client must log in again transparently
+		if err = c.Pause(t.Context(), torrent.Hash); err != nil {
+			t.Fatal(err)
+		}
+		list, err := c.List(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].DownloadDir != "/downloads/series" || list[0].Category != "series" || list[0].Status != client.STATUS_STOPPED {
+			t.Errorf("unexpected list %v", list)
+		}
+		if s.Logins() != 2 {
+			t.Errorf("expected 2 logins, got %d", s.Logins())
+		}
+
+		if err = c.Start(t.Context(), torrent.Hash); err != nil {
+			t.Fatal(err)
+		}
+		if list, _ = c.List(t.Context()); list[0].Status != client.STATUS_DOWNLOADING {
+			t.Errorf("unexpected status %s", list[0].Status)
+		}
+
+		if err = c.Delete(t.Context(), torrent.Hash, true); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Torrents()) != 0 {
+			t.Error("torrent is not deleted")
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	s, c := start(t)
+	added := s.AddTorrent(fakeqbittorrent.Torrent{
+		Name: "ubuntu.iso", State: "stalledUP", Progress: 1, Ratio: 1.5, NumLeechs: 3, CompletionOn: 1700000000,
+		Files: []fakeqbittorrent.File{{Name: "ubuntu.iso", Size: 10, Progress: 0.5, Priority: 0}},
+	})
+
+	list, err := c.List(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Status != client.STATUS_SEEDING || list[0].PeersGettingFromUs != 3 || list[0].DoneDate.Unix() != 1700000000 {
+		t.Errorf("unexpected list %v", list)
+	}
+
+	files, err := c.Files(t.Context(), added.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].BytesCompleted != 5 || files[0].Wanted {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestWrongCredentials(t *testing.T) {
+	s := fakeqbittorrent.NewServer()
+	t.Cleanup(s.Close)
+	c, _ := qbittorrent.New(s.URL, "admin", "wrong", nil)
+	if _, err := c.List(t.Context()); err == nil {
+		t.Error("expected login error")
+	}
+}
+
+func TestServerError(t *testing.T) {
+	s, c := start(t)
+	added := s.AddTorrent(fakeqbittorrent.Torrent{Name: "ubuntu"})
+	s.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1})
+
+	if list, err := c.List(t.Context()); err != nil || len(list) != 1 {
+		t.Errorf("expected recovery on retry, got %v", err)
+	}
+
+	s.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1})
+	if err := c.Delete(t.Context(), added.Hash, false); err == nil {
+		t.Error("torrents/delete is not idempotent, it must not be retried")
+	}
+
+	s.Inject(fault.Fault{Status: http.StatusBadGateway})
+	_, err := c.List(t.Context())
+	if resilience.Message(err) != "qBittorrent unavailable, retrying in 30 s" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSetFiles(t *testing.T) {
+	s, c := start(t)
+	added := s.AddTorrent(fakeqbittorrent.Torrent{
+		Name:  "series",
+		Files: []fakeqbittorrent.File{{Name: "s01e01.mkv", Priority: 1}, {Name: "s01e02.mkv", Priority: 1}},
+	})
+
+	if err := c.SetWanted(t.Context(), added.Hash, []int{0}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetPriority(t.Context(), added.Hash, []int{1}, client.PRIORITY_HIGH); err != nil {
+		t.Fatal(err)
+	}
+	files, err := c.Files(t.Context(), added.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Wanted || !files[1].Wanted || files[1].Priority != client.PRIORITY_HIGH {
+		t.Errorf("unexpected files %v", files)
+	}
+	if err = c.SetPriority(t.Context(), added.Hash, []int{1}, client.PRIORITY_LOW); !errors.Is(err, client.ErrUnsupported) {
+		t.Errorf("expected unsupported low priority, got %v", err)
+	}
+	if slices.Contains(client.Priorities(c), client.PRIORITY_LOW) {
+		t.Error("low priority must not be offered")
+	}
+}
+
+func TestMove(t *testing.T) {
+	s, c := start(t)
+	added := s.AddTorrent(fakeqbittorrent.Torrent{Name: "ubuntu", SavePath: "/downloads"})
+
+	if err := c.Move(t.Context(), added.Hash, "/downloads/movie"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := c.List(t.Context()); err != nil || list[0].DownloadDir != "/downloads/movie" {
+		t.Errorf("unexpected result %v %v", list, err)
+	}
+}
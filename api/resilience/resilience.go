@@ -0,0 +1,185 @@
+// Package resilience retries the calls to backends with jittered backoff and stops calling
+// a backend which keeps failing (circuit breaker), so users get "Jackett unavailable, retrying in 30 s"
+// at once instead of a timeout on every action
+package resilience
+
+import (
+	"context"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Policy struct {
+	Attempts  int           // of idempotent calls, the others are tried once
+	BaseDelay time.Duration // before the first retry, doubled before each next one and jittered
+	MaxDelay  time.Duration
+	Threshold int           // consecutive failed attempts which open the circuit
+	Cooldown  time.Duration // the circuit stays open that long, then a single call probes the backend
+}
+
+// DefaultPolicy is taken by New, tests make it faster
+var DefaultPolicy = Policy{
+	Attempts:  3,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  5 * time.Second,
+	Threshold: 3,
+	Cooldown:  30 * time.Second,
+}
+
+// Backend is the circuit breaker of one backend instance
+type Backend struct {
+	Name   string
+	policy Policy
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	lastErr   error
+}
+
+func New(name string) *Backend {
+	return &Backend{Name: name, policy: DefaultPolicy}
+}
+
+// HTTPError is a reply with unexpected status, only 5xx, 408 and 429 are worth retrying
+type HTTPError struct {
+	Code   int
+	Status string
+}
+
+func (e *HTTPError) Error() string {
+	return "request error: " + e.Status
+}
+
+// StatusError makes HTTPError of the reply
+func StatusError(res *http.Response) error {
+	return &HTTPError{res.StatusCode, res.Status}
+}
+
+// Transient tells if the error may go away by itself: network errors, timeouts and server side http errors
+func Transient(err error) bool {
+	var httpErr *HTTPError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Code >= 500 || httpErr.Code == http.StatusRequestTimeout || httpErr.Code == http.StatusTooManyRequests
+	case errors.As(err, &netErr):
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// UnavailableError is returned when the backend keeps failing or the circuit is open
+type UnavailableError struct {
+	Backend string
+	RetryIn time.Duration // till the circuit lets calls through again, 0 if it's not open
+	Err     error         // the last failure
+}
+
+func (e *UnavailableError) Error() string {
+	return e.Message() + ": " + e.Err.Error()
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Message is the text for users, without details of the failure
+func (e *UnavailableError) Message() string {
+	if e.RetryIn <= 0 {
+		return e.Backend + " unavailable, try again later"
+	}
+	return e.Backend + " unavailable, retrying in " + strconv.Itoa(int(math.Ceil(e.RetryIn.Seconds()))) + " s"
+}
+
+// Message is the text of the error for users: friendly one if a backend is unavailable, the error itself otherwise
+func Message(err error) string {
+	var unavailable *UnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable.Message()
+	}
+	return err.Error()
+}
+
+// acquire lets the call through unless the circuit is open, when the cooldown is over the first caller
+// probes the backend alone while the circuit is kept open for the others
+func (b *Backend) acquire() (wait time.Duration, last error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return b.openUntil.Sub(now), b.lastErr
+	}
+	if b.failures >= b.policy.Threshold {
+		b.openUntil = now.Add(b.policy.Cooldown)
+	}
+	return 0, nil
+}
+
+func (b *Backend) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures, b.openUntil, b.lastErr = 0, time.Time{}, nil
+}
+
+// failed counts the failure and returns the time the circuit is open for, 0 if it's still closed
+func (b *Backend) failed(err error) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastErr = err
+	if b.failures++; b.failures >= b.policy.Threshold {
+		b.openUntil = time.Now().Add(b.policy.Cooldown)
+		return b.policy.Cooldown
+	}
+	return 0
+}
+
+func (b *Backend) delay(attempt int) time.Duration {
+	d := min(b.policy.BaseDelay<<attempt, b.policy.MaxDelay)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1) // "equal jitter": not less than a half, so the retries don't bunch up
+}
+
+// Do calls fn, repeating idempotent calls on transient errors. Errors which are not transient
+// are returned as is, the backend is alive then. Failures are *UnavailableError
+func (b *Backend) Do(ctx context.Context, idempotent bool, fn func(ctx context.Context) error) error {
+	attempts := 1
+	if idempotent {
+		attempts = max(b.policy.Attempts, 1)
+	}
+	var err error
+	for attempt := range attempts {
+		if wait, last := b.acquire(); wait > 0 {
+			return &UnavailableError{b.Name, wait, last}
+		}
+		if err = fn(ctx); ctx.Err() != nil {
+			return err // the caller is gone, that says nothing about the backend
+		}
+		if err == nil || !Transient(err) {
+			b.succeeded()
+			return err
+		}
+		if open := b.failed(err); open > 0 { // a failed probe opens it once again as well
+			return &UnavailableError{b.Name, open, err}
+		}
+		if attempt == attempts-1 {
+			break
+		}
+		select {
+		case <-time.After(b.delay(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+	return &UnavailableError{b.Name, 0, err}
+}
@@ -0,0 +1,95 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errDown = &HTTPError{http.StatusBadGateway, "502 Bad Gateway"}
+
+func newBackend() *Backend {
+	return &Backend{Name: "Jackett", policy: Policy{
+		Attempts:  3,
+		BaseDelay: time.Millisecond,
+		MaxDelay:  2 * time.Millisecond,
+		Threshold: 3,
+		Cooldown:  50 * time.Millisecond,
+	}}
+}
+
+// calls counts the calls of fn which fails that many times first
+func failing(times int, calls *int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if *calls++; *calls <= times {
+			return errDown
+		}
+		return nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	b := newBackend()
+	calls := 0
+	if err := b.Do(t.Context(), true, failing(2, &calls)); err != nil || calls != 3 {
+		t.Errorf("expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	err := b.Do(t.Context(), false, failing(1, &calls))
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) || calls != 1 {
+		t.Errorf("not idempotent calls must not be retried, got %v after %d calls", err, calls)
+	}
+	if Message(err) != "Jackett unavailable, try again later" {
+		t.Errorf("unexpected message %q", Message(err))
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	b := newBackend()
+	calls := 0
+	notFound := &HTTPError{http.StatusNotFound, "404 Not Found"}
+	err := b.Do(t.Context(), true, func(ctx context.Context) error { calls++; return notFound })
+	if err != notFound || calls != 1 {
+		t.Errorf("permanent errors must be returned as is, got %v after %d calls", err, calls)
+	}
+}
+
+func TestCircuit(t *testing.T) {
+	b := newBackend()
+	calls := 0
+	err := b.Do(t.Context(), true, failing(100, &calls))
+	if Message(err) != "Jackett unavailable, retrying in 1 s" || calls != 3 {
+		t.Errorf("unexpected %v after %d calls", err, calls)
+	}
+	if err = b.Do(t.Context(), true, failing(0, &calls)); err == nil || calls != 3 {
+		t.Errorf("open circuit must fail at once, got %v after %d calls", err, calls)
+	}
+
+	time.Sleep(b.policy.Cooldown)
+	calls = 0
+	if err = b.Do(t.Context(), true, failing(1, &calls)); err == nil || calls != 1 {
+		t.Errorf("failed probe must open the circuit again, got %v after %d calls", err, calls)
+	}
+	time.Sleep(b.policy.Cooldown)
+	calls = 0
+	if err = b.Do(t.Context(), true, failing(0, &calls)); err != nil || calls != 1 {
+		t.Errorf("successful probe must close the circuit, got %v after %d calls", err, calls)
+	}
+	if err = b.Do(t.Context(), true, failing(0, &calls)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	b := newBackend()
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	calls := 0
+	err := b.Do(ctx, true, func(ctx context.Context) error { calls++; return ctx.Err() })
+	if !errors.Is(err, context.Canceled) || calls != 1 || b.failures != 0 {
+		t.Errorf("canceled calls must not be retried nor counted, got %v after %d calls", err, calls)
+	}
+}
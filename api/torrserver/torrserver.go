@@ -1,16 +1,16 @@
 package torrserver
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"torrentino/api/resilience"
 	"torrentino/common"
-	"torrentino/common/utils"
 )
 
 type TSListItem struct {
@@ -29,7 +29,36 @@ type TSListItem struct {
 	}
 }
 
-var url = "http://" + common.Settings.Torrserver.Host + ":" + strconv.Itoa(common.Settings.Torrserver.Port) + "/torrents"
+// TSStatus is the reply of "get" action, the files are known once the metadata is fetched
+type TSStatus struct {
+	Title       string `json:"title"`
+	Name        string `json:"name"`
+	Hash        string `json:"hash"`
+	TorrentSize int64  `json:"torrent_size"`
+	FileStats   []struct {
+		Id     int    `json:"id"`
+		Path   string `json:"path"`
+		Length int64  `json:"length"`
+	} `json:"file_stats"`
+}
+
+// DEFAULT_PORT is used when the settings have none
+const DEFAULT_PORT = 8090
+
+// Client talks to one TorrServer instance
+type Client struct {
+	url     string // of the server root
+	backend *resilience.Backend
+}
+
+// NewClient builds the client of the TorrServer at cfg host and port
+func NewClient(cfg common.HostPort) *Client {
+	port := cfg.Port
+	if port == 0 {
+		port = DEFAULT_PORT
+	}
+	return &Client{"http://" + cfg.Host + ":" + strconv.Itoa(port), resilience.New("TorrServer")}
+}
 
 /*
    {
@@ -43,31 +72,60 @@ var url = "http://" + common.Settings.Torrserver.Host + ":" + strconv.Itoa(commo
    }
 */
 
-func List() (*[]TSListItem, error) {
-	var res *http.Response
-	var err error
-	var data []byte
-
-	err = utils.WithTimeout(
-		func() error {
-			var err error
-			res, err = http.Post(
-				url,
-				"application/json",
-				strings.NewReader("{\"action\" : \"list\"}"),
-			)
-			if res != nil && res.StatusCode != 200 {
-				return fmt.Errorf("request error: %s", res.Status)
-			}
+// TIMEOUT limits every attempt, TorrServer answers at once or not at all
+const TIMEOUT = 3 * time.Second
+
+// post sends the action and reads the reply, every action is safe to repeat: add of the same link
+// updates the torrent, rem of the removed one does nothing
+func (c *Client) post(ctx context.Context, body string) (data []byte, err error) {
+	err = c.backend.Do(ctx, true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "POST", c.url+"/torrents", strings.NewReader(body))
+		if err != nil {
 			return err
-		},
-		3*time.Second,
-	)
-	if err != nil {
-		return nil, err
-	}
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return resilience.StatusError(res)
+		}
+		data, err = io.ReadAll(res.Body)
+		return err
+	})
+	return data, err
+}
 
-	data, err = io.ReadAll(res.Body)
+// Echo returns the version of the server
+func (c *Client) Echo(ctx context.Context) (version string, err error) {
+	err = c.backend.Do(ctx, true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "GET", c.url+"/echo", nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			return resilience.StatusError(res)
+		}
+		data, err := io.ReadAll(res.Body)
+		version = strings.TrimSpace(string(data))
+		return err
+	})
+	return version, err
+}
+
+func (c *Client) List(ctx context.Context) (*[]TSListItem, error) {
+	data, err := c.post(ctx, "{\"action\" : \"list\"}")
 	if err != nil {
 		return nil, err
 	}
@@ -86,43 +144,47 @@ func List() (*[]TSListItem, error) {
 	return &List, nil
 }
 
-func Add(link string, title string, poster string) error {
-
-	return utils.WithTimeout(
-		func() error {
-			res, err := http.Post(
-				url,
-				"application/json",
-				strings.NewReader("{\"action\" : \"add\","+
-					"\"link\" : \""+link+"\","+
-					"\"title\" : \""+title+"\","+
-					"\"poster\" : \""+poster+"\","+
-					"\"save_to_db\" : true}"),
-			)
-			if res != nil && res.StatusCode != 200 {
-				return fmt.Errorf("request error: %s", res.Status)
-			}
-			return err
-		},
-		3*time.Second,
-	)
+func (c *Client) Add(ctx context.Context, link string, title string, poster string) error {
+	_, err := c.add(ctx, link, title, poster, true)
+	return err
 }
 
-func Delete(hash string) error {
-
-	return utils.WithTimeout(
-		func() error {
-			res, err := http.Post(
-				url,
-				"application/json",
-				strings.NewReader(
-					"{\"action\" : \"rem\","+
-						"\"hash\" : \""+hash+"\"}"),
-			)
-			if res != nil && res.StatusCode != 200 {
-				return fmt.Errorf("request error: %s", res.Status)
-			}
-			return err
-		},
-		3*time.Second)
+// Preload adds the torrent without saving it to the database, e.g. to fetch the metadata of a magnet,
+// the reply has the hash the server knows the torrent by
+func (c *Client) Preload(ctx context.Context, link string, title string) (*TSStatus, error) {
+	data, err := c.add(ctx, link, title, "", false)
+	if err != nil {
+		return nil, err
+	}
+	var status TSStatus
+	if err = json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) add(ctx context.Context, link string, title string, poster string, saveToDB bool) ([]byte, error) {
+	body, err := json.Marshal(map[string]any{"action": "add", "link": link, "title": title, "poster": poster, "save_to_db": saveToDB})
+	if err != nil {
+		return nil, err
+	}
+	return c.post(ctx, string(body))
+}
+
+func (c *Client) Get(ctx context.Context, hash string) (*TSStatus, error) {
+	data, err := c.post(ctx, "{\"action\" : \"get\", \"hash\" : \""+hash+"\"}")
+	if err != nil {
+		return nil, err
+	}
+	var status TSStatus
+	if err = json.Unmarshal(data, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) Delete(ctx context.Context, hash string) error {
+	_, err := c.post(ctx, "{\"action\" : \"rem\","+
+		"\"hash\" : \""+hash+"\"}")
+	return err
 }
@@ -0,0 +1,60 @@
+package torrserver_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"torrentino/api/torrserver"
+	"torrentino/common"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+)
+
+func TestAddListDelete(t *testing.T) {
+	b := backends.Start(t)
+
+	if err := b.TorrserverClient.Add(t.Context(), "magnet:?xt=urn:btih:abcdef", "Ubuntu", ""); err != nil {
+		t.Fatal(err)
+	}
+	list, err := b.TorrserverClient.List(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*list) != 1 || (*list)[0].Hash != "abcdef" || (*list)[0].Title != "Ubuntu" {
+		t.Errorf("unexpected list %v", *list)
+	}
+	if err = b.TorrserverClient.Delete(t.Context(), "abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Torrserver.Torrents()) != 0 {
+		t.Error("torrent is not deleted")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	b := backends.Start(t)
+	b.Torrserver.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1})
+	if _, err := b.TorrserverClient.List(t.Context()); err != nil {
+		t.Errorf("expected recovery on retry, got %v", err)
+	}
+
+	b.Torrserver.Inject(fault.Fault{Delay: 4 * time.Second, Times: 1})
+	start := time.Now()
+	if _, err := b.TorrserverClient.List(t.Context()); err != nil {
+		t.Errorf("expected retry after timeout, got %v", err)
+	}
+	if time.Since(start) > 3500*time.Millisecond {
+		t.Error("timeout is not respected")
+	}
+}
+
+func TestDefaultPort(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel() // the request is not sent, its url is seen in the error
+	if _, err := torrserver.NewClient(common.HostPort{Host: "ts"}).Echo(ctx); err == nil || !strings.Contains(err.Error(), "http://ts:8090/") {
+		t.Errorf("expected the request to the default port, got %v", err)
+	}
+}
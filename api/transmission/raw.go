@@ -0,0 +1,81 @@
+package transmission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"torrentino/api/resilience"
+)
+
+const SESSION_HEADER = "X-Transmission-Session-Id"
+
+// raw is the JSON-RPC transport of the client: the endpoint is taken as is and the http client
+// may trust a private CA, the wire types of transmissionrpc are reused for the arguments
+type raw struct {
+	endpoint string
+	username string
+	password string
+	http     *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+// call sends the method and decodes the arguments of the reply into result, unless it's nil
+func (r *raw) call(ctx context.Context, method string, arguments any, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, TIMEOUT)
+	defer cancel()
+	request := map[string]any{"method": method}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	for range 2 { // the first attempt may only get the session id
+		req, err := http.NewRequestWithContext(ctx, "POST", r.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if r.username != "" {
+			req.SetBasicAuth(r.username, r.password)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		r.mu.Lock()
+		req.Header.Set(SESSION_HEADER, r.sessionID)
+		r.mu.Unlock()
+		res, err := r.http.Do(req)
+		if err != nil {
+			return err
+		}
+		var reply struct {
+			Result    string          `json:"result"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&reply)
+		res.Body.Close()
+		switch {
+		case res.StatusCode == http.StatusConflict:
+			r.mu.Lock()
+			r.sessionID = res.Header.Get(SESSION_HEADER)
+			r.mu.Unlock()
+			continue
+		case res.StatusCode != http.StatusOK:
+			return errors.Wrap(resilience.StatusError(res), method)
+		case err != nil:
+			return errors.Wrap(err, method)
+		case reply.Result != "success":
+			return errors.New(method + ": " + reply.Result)
+		case result != nil && len(reply.Arguments) > 0:
+			return errors.Wrap(json.Unmarshal(reply.Arguments, result), method)
+		}
+		return nil
+	}
+	return errors.New(method + ": session id is not accepted")
+}
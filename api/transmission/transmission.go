@@ -2,56 +2,293 @@ package transmission
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/hekmon/transmissionrpc/v2"
+	"github.com/pkg/errors"
 
-	"torrentino/common"
+	"torrentino/api/client"
+	"torrentino/api/resilience"
 )
 
-var Transmission *transmissionrpc.Client
+// Client implements client.DownloadClient over Transmission RPC
+type Client struct {
+	rpc     *raw
+	backend *resilience.Backend
+}
 
-func Add(torrentUrlOrMagnet string, downloadDir string) (torrent transmissionrpc.Torrent, err error) {
-	torrent, err = Transmission.TorrentAdd(context.TODO(), transmissionrpc.TorrentAddPayload{
-		Filename: &torrentUrlOrMagnet,
-		DownloadDir: &downloadDir,
-	})
-	return
+const (
+	DEFAULT_PORT     = 9091
+	DEFAULT_RPC_PATH = "/transmission/rpc"
+	TIMEOUT          = 30 * time.Second // of every attempt
+)
+
+// the fields of torrent-get, enough for convert()
+var torrentFields = []string{
+	"id", "hashString", "name", "status", "error", "errorString", "percentDone", "downloadedEver", "uploadedEver",
+	"uploadRatio", "peersGettingFromUs", "peersSendingToUs", "addedDate", "doneDate", "downloadDir", "totalSize",
+	"labels", "files", "fileStats",
 }
 
-func Delete(torrentId int64) (err error) {
-	return Transmission.TorrentRemove(context.TODO(), transmissionrpc.TorrentRemovePayload{
-		IDs:             []int64{torrentId},
-		DeleteLocalData: true,
+// New connects to rpc endpoint like "https://nas.example.com/transmission/rpc", the endpoint is used as is,
+// "/transmission/rpc" is added only when it has no path. Credentials embedded in the endpoint are used
+// when username is empty, tlsConfig (e.g. with a private CA) may be nil
+func New(endpoint string, username string, password string, tlsConfig *tls.Config) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "transmission endpoint")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("transmission endpoint: unsupported scheme " + u.Scheme)
+	}
+	if username == "" && u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+	if u.Path == "" || u.Path == "/" {
+		u.Path, u.RawPath = DEFAULT_RPC_PATH, ""
+	}
+	httpClient := http.DefaultClient
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpClient = &http.Client{Transport: transport}
+	}
+	return &Client{
+		&raw{endpoint: u.String(), username: username, password: password, http: httpClient},
+		resilience.New("Transmission"),
+	}, nil
+}
+
+// do calls the daemon through the circuit breaker, idempotent calls are retried
+func (c *Client) do(ctx context.Context, idempotent bool, method string, arguments any, result any) error {
+	return c.backend.Do(ctx, idempotent, func(ctx context.Context) error {
+		return c.rpc.call(ctx, method, arguments, result)
 	})
 }
 
-func Start(torrentId int64) (err error) {
-	return Transmission.TorrentStartIDs(context.TODO(), []int64{torrentId})
+// get calls torrent-get for the torrents with the hashes, all of them if there are none
+func (c *Client) get(ctx context.Context, fields []string, hashes ...string) ([]transmissionrpc.Torrent, error) {
+	arguments := map[string]any{"fields": fields}
+	if len(hashes) > 0 {
+		arguments["ids"] = hashes
+	}
+	var result struct {
+		Torrents []transmissionrpc.Torrent `json:"torrents"`
+	}
+	err := c.do(ctx, true, "torrent-get", arguments, &result)
+	return result.Torrents, err
 }
 
-func Pause(torrentId int64) (err error) {
-	return Transmission.TorrentStopIDs(context.TODO(), []int64{torrentId})
+func deref[T any](p *T) (v T) {
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+func convert(t *transmissionrpc.Torrent) client.Torrent {
+	result := client.Torrent{
+		Hash:               deref(t.HashString),
+		Name:               deref(t.Name),
+		Status:             client.STATUS_UNKNOWN,
+		Error:              deref(t.ErrorString),
+		PercentDone:        deref(t.PercentDone),
+		DownloadedEver:     deref(t.DownloadedEver),
+		UploadedEver:       deref(t.UploadedEver),
+		UploadRatio:        max(deref(t.UploadRatio), 0), // -1 means "none"
+		PeersGettingFromUs: deref(t.PeersGettingFromUs),
+		PeersSendingToUs:   deref(t.PeersSendingToUs),
+		AddedDate:          deref(t.AddedDate),
+		DoneDate:           deref(t.DoneDate),
+		DownloadDir:        deref(t.DownloadDir),
+	}
+	if t.Status != nil {
+		result.Status = t.Status.String()
+	}
+	if deref(t.Error) != 0 {
+		result.Status = client.STATUS_ERROR
+	}
+	if t.TotalSize != nil {
+		result.TotalSize = int64(t.TotalSize.Byte())
+	}
+	if len(t.Labels) > 0 {
+		result.Category = t.Labels[0]
+	}
+	result.Files = convertFiles(t)
+	return result
 }
 
-func List() (torrents *[]transmissionrpc.Torrent, err error) {
-	t, err := Transmission.TorrentGetAll(context.TODO())
-	return &t, err
+func convertFiles(t *transmissionrpc.Torrent) []client.File {
+	files := make([]client.File, len(t.Files))
+	for i, f := range t.Files {
+		files[i] = client.File{Name: f.Name, Length: f.Length, BytesCompleted: f.BytesCompleted, Wanted: true}
+		if i < len(t.FileStats) {
+			files[i].Wanted = t.FileStats[i].Wanted
+			files[i].Priority = int(t.FileStats[i].Priority)
+		}
+	}
+	return files
 }
 
-func init() {
-	var trn = &common.Settings.Transmission
+func (c *Client) Add(ctx context.Context, torrentUrlOrMagnet string, options client.AddOptions) (client.Torrent, error) {
+	payload := transmissionrpc.TorrentAddPayload{
+		Filename: &torrentUrlOrMagnet,
+		Paused:   &options.Paused,
+	}
+	if options.DownloadDir != "" {
+		payload.DownloadDir = &options.DownloadDir
+	}
+	var added struct {
+		Added     *transmissionrpc.Torrent `json:"torrent-added"`
+		Duplicate *transmissionrpc.Torrent `json:"torrent-duplicate"`
+	}
+	if err := c.do(ctx, false, "torrent-add", payload, &added); err != nil {
+		return client.Torrent{}, err
+	}
+	torrent := added.Added
+	if torrent == nil {
+		torrent = added.Duplicate
+	}
+	if torrent == nil {
+		return client.Torrent{}, errors.New("torrent-add: no torrent in the reply")
+	}
 	var err error
-	/* todo: transmissionrpc/v3
-	endpoint, err := url.Parse("http://" + trn.Host + ":" + strconv.Itoa(trn.Port) + "/transmission/rpc")
+	labels := options.Labels
+	if len(labels) == 0 && options.Category != "" {
+		labels = []string{options.Category}
+	}
+	if len(labels) > 0 && torrent.ID != nil {
+		err = c.do(ctx, true, "torrent-set", transmissionrpc.TorrentSetPayload{IDs: []int64{*torrent.ID}, Labels: labels}, nil)
+	}
+	if err == nil && options.BandwidthGroup != "" && torrent.ID != nil {
+		err = c.do(ctx, true, "torrent-set", map[string]any{"ids": []int64{*torrent.ID}, "group": options.BandwidthGroup}, nil)
+	}
+	return convert(torrent), err
+}
+
+// ids resolves the hash for the methods which don't accept hashes, empty ids would mean "all torrents"
+func (c *Client) ids(ctx context.Context, hash string) ([]int64, error) {
+	found, err := c.get(ctx, []string{"id"}, hash)
 	if err != nil {
-	    log.Fatal(err)
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errors.New("torrent " + hash + " not found")
+	}
+	ids := make([]int64, 0, len(found))
+	for _, t := range found {
+		ids = append(ids, deref(t.ID))
 	}
-	Transmission, err = transmissionrpc.New(endpoint, nil)
-	*/
-	Transmission, err = transmissionrpc.New(trn.Host, "rpcuser", "rpcpass", nil)
+	return ids, nil
+}
+
+func (c *Client) Delete(ctx context.Context, hash string, deleteData bool) error {
+	ids, err := c.ids(ctx, hash)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, false, "torrent-remove", transmissionrpc.TorrentRemovePayload{IDs: ids, DeleteLocalData: deleteData}, nil)
+}
+
+func (c *Client) Move(ctx context.Context, hash string, dir string) error {
+	ids, err := c.ids(ctx, hash)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	return c.do(ctx, false, "torrent-set-location", map[string]any{"ids": ids, "location": dir, "move": true}, nil)
+}
 
+func (c *Client) Start(ctx context.Context, hash string) error {
+	return c.do(ctx, true, "torrent-start", map[string]any{"ids": []string{hash}}, nil)
+}
+
+func (c *Client) Pause(ctx context.Context, hash string) error {
+	return c.do(ctx, true, "torrent-stop", map[string]any{"ids": []string{hash}}, nil)
+}
+
+func (c *Client) List(ctx context.Context) ([]client.Torrent, error) {
+	torrents, err := c.get(ctx, torrentFields)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]client.Torrent, len(torrents))
+	for i := range torrents {
+		result[i] = convert(&torrents[i])
+	}
+	return result, nil
+}
+
+func (c *Client) Files(ctx context.Context, hash string) ([]client.File, error) {
+	torrents, err := c.get(ctx, []string{"files", "fileStats"}, hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(torrents) == 0 {
+		return nil, nil
+	}
+	return convertFiles(&torrents[0]), nil
+}
+
+func indexes(files []int) []int64 {
+	result := make([]int64, len(files))
+	for i, f := range files {
+		result[i] = int64(f)
+	}
+	return result
+}
+
+func (c *Client) SetWanted(ctx context.Context, hash string, files []int, wanted bool) error {
+	ids, err := c.ids(ctx, hash)
+	if err != nil {
+		return err
+	}
+	payload := transmissionrpc.TorrentSetPayload{IDs: ids}
+	if wanted {
+		payload.FilesWanted = indexes(files)
+	} else {
+		payload.FilesUnwanted = indexes(files)
+	}
+	return c.do(ctx, true, "torrent-set", payload, nil)
+}
+
+func (c *Client) SetPriority(ctx context.Context, hash string, files []int, priority int) error {
+	ids, err := c.ids(ctx, hash)
+	if err != nil {
+		return err
+	}
+	payload := transmissionrpc.TorrentSetPayload{IDs: ids}
+	switch priority {
+	case client.PRIORITY_LOW:
+		payload.PriorityLow = indexes(files)
+	case client.PRIORITY_HIGH:
+		payload.PriorityHigh = indexes(files)
+	default:
+		payload.PriorityNormal = indexes(files)
+	}
+	return c.do(ctx, true, "torrent-set", payload, nil)
+}
+
+// Stats implements client.Reporter with session-get and session-stats
+func (c *Client) Stats(ctx context.Context) (client.Stats, error) {
+	var session transmissionrpc.SessionArguments
+	var stats transmissionrpc.SessionStats
+	err := c.do(ctx, true, "session-get", map[string]any{"fields": []string{"version"}}, &session)
+	if err == nil {
+		err = c.do(ctx, true, "session-stats", nil, &stats)
+	}
+	if err != nil {
+		return client.Stats{}, err
+	}
+	return client.Stats{
+		Version:       deref(session.Version),
+		Torrents:      stats.TorrentCount,
+		Active:        stats.ActiveTorrentCount,
+		DownloadSpeed: stats.DownloadSpeed,
+		UploadSpeed:   stats.UploadSpeed,
+	}, nil
 }
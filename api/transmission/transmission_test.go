@@ -0,0 +1,211 @@
+package transmission_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"torrentino/api/client"
+	"torrentino/api/transmission"
+	"torrentino/common"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+	faketransmission "torrentino/fakes/transmission"
+)
+
+func newClient(t *testing.T, b *backends.Backends) *transmission.Client {
+	c, err := transmission.New(b.Transmission.URL+faketransmission.RPC_PATH, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLifecycle(t *testing.T) {
+	b := backends.Start(t)
+	c := newClient(t, b)
+
+	torrent, err := c.Add(t.Context(), "magnet:?xt=urn:btih:ABCDEF&dn=ubuntu", client.AddOptions{DownloadDir: "/downloads/series"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if torrent.Hash != "abcdef" {
+		t.Errorf("unexpected hash %s", torrent.Hash)
+	}
+
+	b.Transmission.RenewSession() // the client must handle 409 transparently
+	if err = c.Pause(t.Context(), torrent.Hash); err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.List(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].DownloadDir != "/downloads/series" || list[0].Status != client.STATUS_STOPPED {
+		t.Errorf("unexpected list %v", b.Transmission.Torrents())
+	}
+
+	if err = c.Delete(t.Context(), torrent.Hash, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Transmission.Torrents()) != 0 {
+		t.Error("torrent is not deleted")
+	}
+	if err = c.Delete(t.Context(), torrent.Hash, true); err == nil {
+		t.Error("expected error on deleting unknown torrent")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	b := backends.Start(t)
+	c := newClient(t, b)
+	added := b.Transmission.AddTorrent(faketransmission.Torrent{
+		Name:  "series",
+		Files: []faketransmission.File{{Name: "s01e01.mkv", Length: 10, BytesCompleted: 5}, {Name: "s01e02.mkv", Length: 10}},
+	})
+
+	files, err := c.Files(t.Context(), added.HashString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "s01e01.mkv" || files[0].BytesCompleted != 5 || !files[1].Wanted {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	b := backends.Start(t)
+	c := newClient(t, b)
+	b.Transmission.AddTorrent(faketransmission.Torrent{Name: "ubuntu"})
+	b.Transmission.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1})
+
+	if list, err := c.List(t.Context()); err != nil || len(list) != 1 {
+		t.Errorf("expected recovery on retry, got %v", err)
+	}
+
+	b.Transmission.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1})
+	if _, err := c.Add(t.Context(), "magnet:?xt=urn:btih:abcdef", client.AddOptions{}); err == nil {
+		t.Error("torrent-add is not idempotent, it must not be retried")
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	b := backends.Start(t)
+	b.Transmission.Username, b.Transmission.Password = "user", "secret"
+	b.Transmission.AddTorrent(faketransmission.Torrent{Name: "ubuntu"})
+
+	c, _ := transmission.New(b.Transmission.URL+faketransmission.RPC_PATH, "user", "wrong", nil)
+	if _, err := c.List(t.Context()); err == nil {
+		t.Error("expected error on wrong password")
+	}
+	c, _ = transmission.New(b.Transmission.URL+faketransmission.RPC_PATH, "user", "secret", nil)
+	if list, err := c.List(t.Context()); err != nil || len(list) != 1 {
+		t.Errorf("unexpected result %v %v", list, err)
+	}
+	endpoint, _ := url.Parse(b.Transmission.URL + faketransmission.RPC_PATH)
+	endpoint.User = url.UserPassword("user", "secret")
+	c, _ = transmission.New(endpoint.String(), "", "", nil)
+	if _, err := c.List(t.Context()); err != nil {
+		t.Errorf("credentials from the endpoint are not used: %v", err)
+	}
+}
+
+func TestReverseProxyPath(t *testing.T) {
+	b := backends.Start(t)
+	b.Transmission.AddTorrent(faketransmission.Torrent{Name: "ubuntu"})
+	proxy := httptest.NewServer(http.StripPrefix("/nas", b.Transmission.Config.Handler))
+	t.Cleanup(proxy.Close)
+
+	c, err := transmission.New(proxy.URL+"/nas"+faketransmission.RPC_PATH, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, err := c.List(t.Context()); err != nil || len(list) != 1 {
+		t.Errorf("unexpected result %v %v", list, err)
+	}
+	if _, err = transmission.New("ftp://nas", "", "", nil); err == nil {
+		t.Error("expected error on unsupported scheme")
+	}
+}
+
+func TestPrivateCA(t *testing.T) {
+	b := backends.Start(t)
+	b.Transmission.AddTorrent(faketransmission.Torrent{Name: "ubuntu"})
+	nas := httptest.NewTLSServer(b.Transmission.Config.Handler)
+	t.Cleanup(nas.Close)
+	cfg := common.DownloadClient{CAFile: path.Join(t.TempDir(), "ca.pem")}
+	os.WriteFile(cfg.CAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: nas.Certificate().Raw}), 0o600)
+
+	c, _ := transmission.New(nas.URL+faketransmission.RPC_PATH, "", "", nil)
+	if _, err := c.List(t.Context()); err == nil {
+		t.Error("expected error on unknown CA")
+	}
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ = transmission.New(nas.URL+faketransmission.RPC_PATH, "", "", tlsConfig)
+	if list, err := c.List(t.Context()); err != nil || len(list) != 1 {
+		t.Errorf("unexpected result %v %v", list, err)
+	}
+}
+
+func TestSetFiles(t *testing.T) {
+	b := backends.Start(t)
+	c := newClient(t, b)
+	added := b.Transmission.AddTorrent(faketransmission.Torrent{
+		Name:  "series",
+		Files: []faketransmission.File{{Name: "s01e01.mkv"}, {Name: "s01e02.mkv"}, {Name: "s01e03.mkv"}},
+	})
+
+	if err := c.SetWanted(t.Context(), added.HashString, []int{0, 2}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetPriority(t.Context(), added.HashString, []int{1}, client.PRIORITY_HIGH); err != nil {
+		t.Fatal(err)
+	}
+	files, err := c.Files(t.Context(), added.HashString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Wanted || !files[1].Wanted || files[2].Wanted || files[1].Priority != client.PRIORITY_HIGH {
+		t.Errorf("unexpected files %v", files)
+	}
+	if err = c.SetWanted(t.Context(), "unknown", []int{0}, false); err == nil {
+		t.Error("expected error for unknown torrent")
+	}
+}
+
+func TestMove(t *testing.T) {
+	b := backends.Start(t)
+	c := newClient(t, b)
+	added := b.Transmission.AddTorrent(faketransmission.Torrent{Name: "ubuntu", DownloadDir: "/downloads"})
+
+	if err := c.Move(t.Context(), added.HashString, "/downloads/movie"); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := c.List(t.Context()); err != nil || list[0].DownloadDir != "/downloads/movie" {
+		t.Errorf("unexpected result %v %v", list, err)
+	}
+	if err := c.Move(t.Context(), "unknown", "/downloads"); err == nil {
+		t.Error("expected error for unknown torrent")
+	}
+}
+
+func TestLabelsAndGroup(t *testing.T) {
+	b := backends.Start(t)
+	b.Transmission.Username, b.Transmission.Password = "user", "secret"
+	c, _ := transmission.New(b.Transmission.URL+faketransmission.RPC_PATH, "user", "secret", nil)
+
+	_, err := c.Add(t.Context(), "magnet:?xt=urn:btih:abcdef", client.AddOptions{Labels: []string{"movie", "4k"}, BandwidthGroup: "slow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if torrent := b.Transmission.Torrents()[0]; len(torrent.Labels) != 2 || torrent.Labels[1] != "4k" || torrent.Group != "slow" {
+		t.Errorf("unexpected torrent %v", torrent)
+	}
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"torrentino/common"
+	"torrentino/common/utils"
+)
+
+// From returns the user who initiated the update, or nil if it can't be determined
+func From(update *models.Update) *models.User {
+	switch {
+	case update == nil:
+		return nil
+	case update.Message != nil:
+		return update.Message.From
+	case update.EditedMessage != nil:
+		return update.EditedMessage.From
+	case update.CallbackQuery != nil:
+		return &update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From
+	case update.ChosenInlineResult != nil:
+		return &update.ChosenInlineResult.From
+	}
+	return nil
+}
+
+// Name is the user's @username, or the first name if there is none
+func Name(user *models.User) string {
+	switch {
+	case user == nil:
+		return ""
+	case user.Username != "":
+		return "@" + user.Username
+	case user.FirstName != "":
+		return user.FirstName
+	}
+	return strconv.FormatInt(user.ID, 10)
+}
+
+func IsAllowed(userID int64) bool {
+	return isAllowed(common.Current(), userID)
+}
+
+func isAllowed(s *common.SettingsStruct, userID int64) bool {
+	if _, ok := s.UserRoles[userID]; ok {
+		return true
+	}
+	return slices.Index(s.UsersList, userID) != -1
+}
+
+// RoleOf returns the role of a user. Users listed in "users-list" without
+// explicit role are admins, as they were before roles appeared
+func RoleOf(userID int64) (role common.Role, ok bool) {
+	s := common.Current() // the same snapshot for all the lookups
+	if !isAllowed(s, userID) {
+		return role, false
+	}
+	name, ok := s.UserRoles[userID]
+	if !ok {
+		name = common.RoleAdmin
+	}
+	if role, ok = s.Roles[name]; ok {
+		return role, true
+	}
+	role, ok = common.DefaultRoles[name]
+	return role, ok
+}
+
+// CanRun checks if user may run a command ("/downloads", "/torrserver", "/status" or "search")
+func CanRun(userID int64, command string) bool {
+	role, ok := RoleOf(userID)
+	if !ok {
+		return false
+	}
+	return slices.ContainsFunc(role.Commands, func(pattern string) bool {
+		return pattern == "*" || pattern == command
+	})
+}
+
+// CanExecute checks if user may execute paginator action, the action
+// is matched against "*", "prefix:*", "prefix:action" and plain "action"
+func CanExecute(userID int64, prefix string, action string) bool {
+	role, ok := RoleOf(userID)
+	if !ok {
+		return false
+	}
+	base, _, _ := strings.Cut(action, "@") // "download@seedbox" is allowed by "download" as well
+	return slices.ContainsFunc(role.Actions, func(pattern string) bool {
+		return pattern == "*" ||
+			pattern == prefix+":*" ||
+			pattern == prefix+":"+action || pattern == prefix+":"+base ||
+			pattern == action || pattern == base
+	})
+}
+
+// Command restricts handler to users whose role allows the command
+func Command(command string, next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		user := From(update)
+		if user == nil || !CanRun(user.ID, command) {
+			logAttempt(user, "run", command)
+			if update.Message != nil {
+				_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+					ChatID: update.Message.Chat.ID,
+					Text:   "command " + command + " is not permitted",
+				})
+				if err != nil {
+					utils.LogError(err)
+				}
+			}
+			return
+		}
+		next(ctx, b, update)
+	}
+}
+
+// Middleware passes through only updates originated by users from the settings,
+// anything else (including updates without a sender) is rejected
+func Middleware(next bot.HandlerFunc) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update == nil {
+			return
+		}
+		user := From(update)
+		if user != nil && IsAllowed(user.ID) {
+			next(ctx, b, update)
+			return
+		}
+		reject(ctx, b, update, user)
+	}
+}
+
+func reject(ctx context.Context, b *bot.Bot, update *models.Update, user *models.User) {
+	var err error
+	switch {
+	case update.Message != nil:
+		logAttempt(user, "say", update.Message.Text)
+	case update.EditedMessage != nil:
+		logAttempt(user, "edit", update.EditedMessage.Text)
+	case update.CallbackQuery != nil:
+		logAttempt(user, "press", update.CallbackQuery.Data)
+		_, err = b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: update.CallbackQuery.ID,
+			Text:            "access denied",
+			ShowAlert:       true,
+		})
+	case update.InlineQuery != nil:
+		logAttempt(user, "query", update.InlineQuery.Query)
+		_, err = b.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+			InlineQueryID: update.InlineQuery.ID,
+			Results:       []models.InlineQueryResult{},
+			IsPersonal:    true,
+		})
+	default:
+		logAttempt(user, "send", "unsupported update")
+	}
+	if err != nil {
+		utils.LogError(err)
+	}
+}
+
+func logAttempt(user *models.User, verb string, text string) {
+	if user == nil {
+		log.Printf("unknown sender %s: %s", verb, text)
+		return
+	}
+	log.Printf("%d (%s) %s: %s", user.ID, user.Username, verb, text)
+}
+
+// Admins lists the users with role "admin", they get the reports of the bot itself
+func Admins() (result []int64) {
+	s := common.Current()
+	for _, user := range s.UsersList {
+		if _, ok := s.UserRoles[user]; !ok {
+			result = append(result, user)
+		}
+	}
+	for user, name := range s.UserRoles {
+		if name == common.RoleAdmin {
+			result = append(result, user)
+		}
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
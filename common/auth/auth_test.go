@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"torrentino/common"
+	"torrentino/fakes/telegram"
+)
+
+const (
+	admin    = 1
+	viewer   = 2
+	stranger = 3
+)
+
+func setup(t *testing.T, userID int64) (h *telegram.Harness, executed *bool) {
+	common.Settings.UsersList = []int64{admin}
+	common.Settings.UserRoles = map[int64]string{viewer: "viewer"}
+	common.Settings.Roles = nil
+	executed = new(bool)
+	handler := func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		*executed = true
+	}
+	h = telegram.NewHarness(t, 1, userID,
+		bot.WithMiddlewares(Middleware),
+		bot.WithDefaultHandler(Command("search", handler)),
+		bot.WithMessageTextHandler("/torrserver", bot.MatchTypeExact, Command("/torrserver", handler)),
+		bot.WithCallbackQueryDataHandler("list", bot.MatchTypePrefix, handler),
+	)
+	return h, executed
+}
+
+func TestStrangerCallbackIsRejected(t *testing.T) {
+	h, executed := setup(t, stranger)
+	h.PressData(1, "list#action__#delete")
+	if *executed {
+		t.Error("callback of unknown user reached the handler")
+	}
+	answers := h.Answers()
+	if len(answers) != 1 || !answers[0].ShowAlert {
+		t.Errorf("expected alert answer, got %v", answers)
+	}
+}
+
+func TestStrangerMessageIsIgnored(t *testing.T) {
+	h, executed := setup(t, stranger)
+	h.Send("/torrserver")
+	if *executed || len(h.Messages(h.ChatID)) != 0 {
+		t.Error("message of unknown user was processed")
+	}
+}
+
+func TestEditedMessageIsAuthorized(t *testing.T) {
+	h, executed := setup(t, stranger)
+	update := h.TextUpdate(h.ChatID, stranger, "edited")
+	update.EditedMessage, update.Message = update.Message, nil
+	h.Bot.ProcessUpdate(h.Ctx, update)
+	if *executed {
+		t.Error("edited message of unknown user reached the handler")
+	}
+}
+
+func TestRoles(t *testing.T) {
+	h, executed := setup(t, viewer)
+	h.Send("/torrserver")
+	if !*executed {
+		t.Error("viewer should be able to run /torrserver")
+	}
+	if !CanExecute(viewer, "find", "web page") || CanExecute(viewer, "list", "delete") {
+		t.Error("unexpected viewer permissions")
+	}
+	if !CanExecute(admin, "list", "delete") {
+		t.Error("users from users-list must keep full access")
+	}
+
+	common.Settings.Roles = map[string]common.Role{"viewer": {Actions: []string{"find:download", "find:download:movie@nas"}}}
+	if !CanExecute(viewer, "find", "download@seedbox") || !CanExecute(viewer, "find", "download:movie@nas") || CanExecute(viewer, "find", "download:movie@seedbox") {
+		t.Error("plain action must cover every instance, action with instance only that one")
+	}
+
+	common.Settings.Roles = map[string]common.Role{"viewer": {Commands: []string{"search"}}}
+	*executed = false
+	h.Send("/torrserver")
+	if *executed {
+		t.Error("redefined viewer role should not allow /torrserver")
+	}
+	if m := h.Last(); m.Text != "command /torrserver is not permitted" {
+		t.Errorf("unexpected reply %q", m.Text)
+	}
+}
+
+func TestAdmins(t *testing.T) {
+	setup(t, admin)
+	common.Settings.UserRoles[4] = common.RoleAdmin
+	common.Settings.UserRoles[admin] = "downloader"
+	if admins := Admins(); len(admins) != 1 || admins[0] != 4 {
+		t.Errorf("unexpected admins %v", admins)
+	}
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	ENV_PREFIX = "TORRENTINO_"
+	ENV_FILE   = "_FILE" // suffix of the variables pointing to a file with the value, e.g. docker secret
+)
+
+// envFields maps the variable names to the settings fields: TORRENTINO_ and json keys path
+// in upper case with "_" instead of "-", e.g. TORRENTINO_JACKETT_API_KEY
+func envFields(v reflect.Value, prefix string, fields map[string]reflect.Value) map[string]reflect.Value {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			envFields(v.Field(i), prefix, fields)
+		case f.IsExported():
+			if name == "" {
+				name = f.Name
+			}
+			key := prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+			if f.Type.Kind() == reflect.Struct {
+				envFields(v.Field(i), key+"_", fields)
+			} else {
+				fields[key] = v.Field(i)
+			}
+		}
+	}
+	return fields
+}
+
+// setField parses the value by the field type: strings as is, lists of scalars as comma separated
+// values or json, anything else as json
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		items := []string{}
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if field.Type().Elem().Kind() == reflect.String {
+			data, _ := json.Marshal(items)
+			value = string(data)
+		} else {
+			value = "[" + strings.Join(items, ",") + "]"
+		}
+	}
+	target := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+		return err
+	}
+	field.Set(target.Elem())
+	return nil
+}
+
+// applyEnv overrides the settings by TORRENTINO_* variables, NAME_FILE reads the value of NAME from the file
+func (s *SettingsStruct) applyEnv(environ []string) (report []string) {
+	fields := envFields(reflect.ValueOf(s).Elem(), ENV_PREFIX, make(map[string]reflect.Value))
+	values := make(map[string]string)
+	for _, entry := range environ {
+		key, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(key, ENV_PREFIX) {
+			continue
+		}
+		if name, ok := strings.CutSuffix(key, ENV_FILE); ok && fields[name].IsValid() {
+			data, err := os.ReadFile(value)
+			if err != nil {
+				report = append(report, key+": "+err.Error())
+				continue
+			}
+			values[name] = strings.TrimRight(string(data), "\r\n")
+		} else if _, ok := values[key]; !ok { // the file variant wins
+			values[key] = value
+		}
+	}
+	for _, key := range sortedKeys(values) {
+		field, ok := fields[key]
+		if !ok {
+			report = append(report, "unknown environment variable "+key)
+			continue
+		}
+		if err := setField(field, values[key]); err != nil {
+			report = append(report, key+": "+strconv.Quote(values[key])+" is not "+field.Type().String()+" ("+err.Error()+")")
+		}
+	}
+	return report
+}
+
+// EnvNames lists the variables the settings may be overridden with
+func EnvNames() []string {
+	var s SettingsStruct
+	return slices.Sorted(maps.Keys(envFields(reflect.ValueOf(&s).Elem(), ENV_PREFIX, make(map[string]reflect.Value))))
+}
@@ -0,0 +1,116 @@
+// Package owners remembers who added which torrent, so downloads can be attributed to users
+package owners
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"torrentino/common/utils"
+)
+
+const DEFAULT_FILE = "owners.json"
+
+type Owner struct {
+	UserID   int64     `json:"user-id"`
+	UserName string    `json:"user-name"` // @username or first name at the time of adding
+	ChatID   int64     `json:"chat-id"`
+	Query    string    `json:"query"`   // search text the torrent was found by
+	Indexer  string    `json:"indexer"` // tracker id from Jackett
+	Target   string    `json:"target"`  // download client instance or "torrserver"
+	Added    time.Time `json:"added"`
+}
+
+// Name is shown as "added by"
+func (o *Owner) Name() string {
+	if o.UserName != "" {
+		return o.UserName
+	}
+	return strconv.FormatInt(o.UserID, 10)
+}
+
+var (
+	mu     sync.Mutex
+	owners = make(map[string]Owner) // by info hash
+	file   string                   // empty keeps the mapping in memory only
+)
+
+// Open loads the mapping from the file (if it exists) and saves every change there
+func Open(fileName string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	file = fileName
+	owners = make(map[string]Owner)
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "owners.Open")
+	}
+	return errors.Wrap(json.Unmarshal(data, &owners), "owners.Open")
+}
+
+func save() error {
+	if file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(owners, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "owners.save")
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return errors.Wrap(err, "owners.save")
+	}
+	return errors.Wrap(os.Rename(tmp, file), "owners.save")
+}
+
+// Record assigns the torrent to the owner, the latest record wins
+func Record(hash string, owner Owner) {
+	if hash == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if owner.Added.IsZero() {
+		owner.Added = time.Now()
+	}
+	owners[strings.ToLower(hash)] = owner
+	if err := save(); err != nil {
+		utils.LogError(err)
+	}
+}
+
+func Get(hash string) (Owner, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	owner, ok := owners[strings.ToLower(hash)]
+	return owner, ok
+}
+
+// All returns a copy of the records by lower case hash
+func All() map[string]Owner {
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(owners)
+}
+
+// Forget drops the record, e.g. when the torrent is deleted
+func Forget(hash string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := owners[strings.ToLower(hash)]; !ok {
+		return
+	}
+	delete(owners, strings.ToLower(hash))
+	if err := save(); err != nil {
+		utils.LogError(err)
+	}
+}
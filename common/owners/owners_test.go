@@ -0,0 +1,27 @@
+package owners
+
+import (
+	"path"
+	"testing"
+)
+
+func TestPersistence(t *testing.T) {
+	fileName := path.Join(t.TempDir(), "owners.json")
+	if err := Open(fileName); err != nil {
+		t.Fatal(err)
+	}
+	Record("ABCDEF", Owner{UserID: 1, UserName: "@alice", Query: "ubuntu", Indexer: "rutor", Target: "nas"})
+	Record("123456", Owner{UserID: 2})
+	Forget("123456")
+
+	if err := Open(fileName); err != nil { // as after restart
+		t.Fatal(err)
+	}
+	owner, ok := Get("abcdef")
+	if !ok || owner.Name() != "@alice" || owner.Query != "ubuntu" || owner.Target != "nas" || owner.Added.IsZero() {
+		t.Errorf("unexpected owner %v", owner)
+	}
+	if _, ok = Get("123456"); ok {
+		t.Error("forgotten owner is loaded")
+	}
+}
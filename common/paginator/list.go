@@ -51,6 +51,9 @@ func (ls *List) Append(item any) {
 }
 
 func (ls *List) Delete(i int) {
+	if i < 0 || i >= len(ls.index) {
+		return
+	}
 	idx := ls.index[i]
 	ls.list = slices.Delete(ls.list, idx, idx+1) // ls.list = append(ls.list[:idx], ls.list[idx+1:]...)
 	ls.Filter()                                  // <-- just for rebuild the indexes
@@ -72,18 +75,18 @@ func (ls *List) Filter() {
 		ls.index[i] = i
 	}
 	for i := range ls.list {
-		keepItem := ls.filters.Len() == 0
+		keepItem := true // every attribute must pass
 		for attribute, buttons := range ls.filters.Iter() {
 			// stringValue := reflect.Indirect(reflect.ValueOf(ls.list[i])).FieldByName(attribute).String()
 			value := ls.Evaluator.Stringify(i, attribute)
-			keepItem = keepItem || buttons.GetUnsafe(value) || func() bool { //  exact filter on, or all filters is off
+			keepItem = keepItem && (buttons.GetUnsafe(value) || func() bool { //  exact filter on, or all filters is off
 				for _, enabled := range buttons.Iter() {
 					if enabled {
 						return false
 					}
 				}
 				return true
-			}()
+			}())
 		}
 		if keepItem {
 			index = append(index, i)
@@ -165,3 +168,47 @@ func (ls *List) ToggleSorting(attribute string) {
 		ls.sorting.queue = slices.Delete(ls.sorting.queue, idx, idx+1)
 	}
 }
+
+func (ls *List) sortingState() (orders map[string]int8, queue []string) {
+	orders = make(map[string]int8)
+	for attribute, sorting := range ls.sorting.attributes.Iter() {
+		orders[attribute] = sorting.Order
+	}
+	return orders, slices.Clone(ls.sorting.queue)
+}
+
+func (ls *List) restoreSorting(orders map[string]int8, queue []string) {
+	for attribute, order := range orders {
+		if sorting, ok := ls.sorting.attributes.Get(attribute); ok {
+			sorting.Order = order
+		}
+	}
+	ls.sorting.queue = ls.sorting.queue[:0]
+	for _, attribute := range queue {
+		if sorting, ok := ls.sorting.attributes.Get(attribute); ok && sorting.Order != 0 {
+			ls.sorting.queue = append(ls.sorting.queue, attribute)
+		}
+	}
+}
+
+func (ls *List) filtersState() map[string][]string {
+	state := make(map[string][]string)
+	for attribute, buttons := range ls.filters.Iter() {
+		for value, enabled := range buttons.Iter() {
+			if enabled {
+				state[attribute] = append(state[attribute], value)
+			}
+		}
+	}
+	return state
+}
+
+func (ls *List) restoreFilters(state map[string][]string) {
+	for attribute, values := range state {
+		if buttons, ok := ls.filters.Get(attribute); ok {
+			for _, value := range values {
+				buttons.Set(value, true)
+			}
+		}
+	}
+}
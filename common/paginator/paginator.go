@@ -3,13 +3,17 @@ package paginator
 import (
 	"context"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 
+	"torrentino/common/auth"
 	"torrentino/common/utils"
 )
 
@@ -20,11 +24,10 @@ const (
 	CB_NEXT_PAGE      = "next_page"
 	CB_PREV_PAGE      = "prev_page"
 	CB_TOGGLE_FILTERS = "toggle_filters"
+	CB_REFRESH        = "refresh"
 	CB_STUB           = "stub"
 )
 
-var Handlers map[string]string = make(map[string]string)
-
 // ----------------------------------------
 type Builder interface {
 	Header() string
@@ -37,25 +40,52 @@ type Actor interface {
 	Execute(i int, action string) (unselect bool)
 }
 
+// Identifier is implemented by the evaluators whose items keep their identity across reloads,
+// e.g. torrent hash. The selection then follows the item instead of its position, which changes
+// when newer items appear, and is dropped once the item is gone
+type Identifier interface {
+	ID(i int) string
+}
+
+// Refresher is implemented by the builders which get "🔄" button next to the page controls.
+// Refresh is called outside of the lock, as it may take long, and updates the list with Locked().
+// The button is permitted as action "refresh"
+type Refresher interface {
+	Refresh()
+}
+
+// Paginator is safe for concurrent use: Show(), callbacks and Locked() are serialized,
+// so the methods of Builder, Actor and Evaluator are always called under the lock
+// and must not call Show() or Locked() themselves
 type Paginator struct {
 	List
 
 	Builder
 	Actor
+	refresher  Refresher  // nil unless the builder implements it
+	identifier Identifier // nil unless the evaluator implements it
+
+	mu *sync.Mutex // a pointer, as constructors copy the paginator into embedding structs
 
 	bot     *bot.Bot
-	ctx     context.Context
+	ctx     context.Context // becomes the session context on the first Show(), canceled on expiry
+	cancel  context.CancelFunc
 	message *models.Message
 	update  *models.Update
+	userID  int64 // the last user who interacted with the paginator
+	user    string
 
 	extControls  bool
 	activePage   int
 	itemsPerPage int
 	selectedItem int
+	selectedID   string // identity of the selected item, see Identifier
 
 	prefix   string
 	text     string
 	keyboard models.InlineKeyboardMarkup
+
+	lastActive time.Time // guarded by sessions lock
 }
 
 func New(
@@ -63,6 +93,7 @@ func New(
 	prefix string, itemsPerPage int, builder Builder, actor Actor, evaluator Evaluator,
 ) *Paginator {
 	p := &Paginator{
+		mu:           &sync.Mutex{},
 		ctx:          ctx,
 		bot:          b,
 		update:       update,
@@ -70,12 +101,64 @@ func New(
 		prefix:       prefix,
 		selectedItem: -1,
 	}
+	if user := auth.From(update); user != nil {
+		p.userID = user.ID
+		p.user = auth.Name(user)
+	}
 	p.Builder = builder
 	p.Actor = actor
+	p.refresher, _ = builder.(Refresher)
+	p.identifier, _ = evaluator.(Identifier)
 	p.List.Evaluator = evaluator
 	return p
 }
 
+// Locked runs fn exclusively, use it to modify the list from outside of Actor, e.g. on reload
+func (p *Paginator) Locked(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn()
+}
+
+// Context is done when the session expires, background jobs bound to the paginator should watch it
+func (p *Paginator) Context() context.Context {
+	return p.ctx
+}
+
+// UserID is the last user who interacted with the paginator, e.g. who pressed the action button
+func (p *Paginator) UserID() int64 {
+	return p.userID
+}
+
+// UserName is the display name of the user, see UserID
+func (p *Paginator) UserName() string {
+	return p.user
+}
+
+// ChatID of the list message
+func (p *Paginator) ChatID() int64 {
+	if p.message != nil {
+		return p.message.Chat.ID
+	}
+	if p.update != nil && p.update.Message != nil {
+		return p.update.Message.Chat.ID
+	}
+	return 0
+}
+
+func (p *Paginator) Bot() *bot.Bot {
+	return p.bot
+}
+
+// Reply makes an update for another paginator in the same chat on behalf of the last user,
+// e.g. for a drill-down view opened by an action
+func (p *Paginator) Reply() *models.Update {
+	return &models.Update{Message: &models.Message{
+		Chat: models.Chat{ID: p.ChatID()},
+		From: &models.User{ID: p.userID},
+	}}
+}
+
 // ----------"Builder" interface----------------
 func (p *Paginator) Header() string {
 	var fromIndex, toIndex = p.pageBounds()
@@ -138,6 +221,39 @@ func (p *Paginator) ReplyDocument(doc *models.InputFileUpload) {
 	}
 }
 
+// selectItem selects the item at position i of the filtered and sorted list, -1 drops the selection
+func (p *Paginator) selectItem(i int) {
+	p.selectedItem, p.selectedID = i, ""
+	if i >= 0 && p.identifier != nil {
+		p.selectedID = p.identifier.ID(i)
+	}
+}
+
+// follow finds the position of the selected item once the list is reloaded or reordered
+func (p *Paginator) follow() {
+	if p.identifier == nil || p.selectedID == "" {
+		return
+	}
+	p.selectedItem = -1
+	for i := range p.Len() {
+		if p.identifier.ID(i) == p.selectedID {
+			p.selectedItem = i
+			return
+		}
+	}
+	p.selectedID = ""
+}
+
+// keep the active page and selected item within the list, it may shrink on reload or delete
+func (p *Paginator) clamp() {
+	if p.selectedItem >= p.Len() {
+		p.selectItem(-1)
+	}
+	if lastPage := max(p.Len()-1, 0) / p.itemsPerPage; p.activePage > lastPage {
+		p.activePage = lastPage
+	}
+}
+
 func (p *Paginator) pageBounds() (int, int) {
 	var maxItems int = p.Len()
 	var fromIndex = p.activePage * p.itemsPerPage
@@ -251,11 +367,14 @@ func (p *Paginator) buildKeyboard() [][]models.InlineKeyboardButton {
 		chooseButton(p.activePage < ((p.Len()-1)/p.itemsPerPage),
 			[2]buttonData{{"➡", p.prefix + CB_NEXT_PAGE}, {"-", p.prefix + CB_STUB}}),
 	}
+	if p.refresher != nil && auth.CanExecute(p.userID, p.prefix, CB_REFRESH) {
+		row = slices.Insert(row, 2, models.InlineKeyboardButton{Text: "🔄", CallbackData: p.prefix + CB_REFRESH})
+	}
 	keyboard = append(keyboard, row)
 
 	if !p.extControls && (p.selectedItem >= fromIndex) && (p.selectedItem < toIndex) {
 		row = []models.InlineKeyboardButton{}
-		for i, action := range p.Actor.Actions(p.selectedItem) {
+		for i, action := range p.allowedActions(p.selectedItem) {
 			row = append(row, models.InlineKeyboardButton{
 				Text:         action,
 				CallbackData: p.prefix + CB_ACTION + action,
@@ -272,26 +391,45 @@ func (p *Paginator) buildKeyboard() [][]models.InlineKeyboardButton {
 	return keyboard
 }
 
+func (p *Paginator) allowedActions(i int) (result []string) {
+	for _, action := range p.Actor.Actions(i) {
+		if auth.CanExecute(p.userID, p.prefix, action) {
+			result = append(result, action)
+		}
+	}
+	return result
+}
+
 func (p *Paginator) Show() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.show()
+}
+
+func (p *Paginator) show() {
 	var err error
+	if p.ctx.Err() != nil { // expired session must not revive its keyboard
+		return
+	}
 	p.Filter()
 	p.Sort()
+	p.follow()
+	p.clamp()
 	text := p.buildText()
 	keyboard := p.buildKeyboard()
 
 	if p.message == nil { // Show() first call?
-		if callbackHandlerID, ok := Handlers[p.prefix]; ok {
-			p.bot.UnregisterHandler(callbackHandlerID)
-		}
-		Handlers[p.prefix] = p.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, p.prefix, bot.MatchTypePrefix, p.callbackHandler)
 		p.text = text
 		p.keyboard.InlineKeyboard = keyboard
 		p.message, err = p.bot.SendMessage(p.ctx, &bot.SendMessageParams{
-			ChatID:      p.update.Message.Chat.ID,
+			ChatID:      p.ChatID(),
 			Text:        p.text,
 			ParseMode:   models.ParseModeHTML,
 			ReplyMarkup: p.keyboard,
 		})
+		if err == nil {
+			register(p)
+		}
 	} else {
 		textChanged := text != p.text
 		kbdChanged := !reflect.DeepEqual(keyboard, p.keyboard.InlineKeyboard)
@@ -324,22 +462,51 @@ func (p *Paginator) Show() {
 }
 
 func (p *Paginator) callbackHandler(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if p.refresher != nil && update.CallbackQuery.Data == p.prefix+CB_REFRESH {
+		p.refresh(ctx, b, update)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	cmd := strings.TrimPrefix(update.CallbackQuery.Data, p.prefix)
+	if cmd == "" {
+		return
+	}
+	p.userID = update.CallbackQuery.From.ID
+	p.user = auth.Name(&update.CallbackQuery.From)
+
+	if strings.HasPrefix(cmd, CB_ACTION) && !auth.CanExecute(p.userID, p.prefix, cmd[len(CB_ACTION):]) {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: update.CallbackQuery.ID,
+			Text:            "action is not permitted",
+			ShowAlert:       true,
+		})
+		p.show() // the keyboard was built for another user, rebuild it for this one
+		return
+	}
+
 	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
 		CallbackQueryID: update.CallbackQuery.ID,
 		Text:            cmd,
 		ShowAlert:       false,
 	})
 
+	// the list may be reloaded since it was shown, the action must hit the item it was built for
+	p.Filter()
+	p.Sort()
+	p.follow()
+
 	if unicode.IsNumber(rune(cmd[0])) {
-		p.selectedItem, _ = strconv.Atoi(cmd)
+		if i, err := strconv.Atoi(cmd); err == nil && i < p.Len() {
+			p.selectItem(i)
+		}
 		p.extControls = false
 	}
 
 	switch cmd {
 	case CB_NEXT_PAGE:
-		if p.activePage < (p.Len() / p.itemsPerPage) {
+		if p.activePage < ((p.Len() - 1) / p.itemsPerPage) {
 			p.activePage++
 		}
 
@@ -357,19 +524,45 @@ func (p *Paginator) callbackHandler(ctx context.Context, b *bot.Bot, update *mod
 		switch cmd[0:10] {
 		case CB_ORDER_BY:
 			p.ToggleSorting(payload)
-			p.selectedItem = -1
+			p.selectItem(-1)
 		case CB_FILTER_BY:
 			split := strings.Split(payload, "/")
 			p.ToggleFilter(split[0], split[1])
 			p.activePage = 0
-			p.selectedItem = -1
+			p.selectItem(-1)
 		case CB_ACTION:
-			if p.selectedItem != -1 {
+			if p.selectedItem != -1 && p.selectedItem < p.Len() {
 				if p.Actor.Execute(p.selectedItem, payload) {
-					p.selectedItem = -1
+					p.selectItem(-1)
 				}
 			}
 		}
 	}
-	p.Show()
+	p.show()
+	p.save()
+}
+
+// refresh runs Refresh without holding the lock, so the other buttons keep working meanwhile
+func (p *Paginator) refresh(ctx context.Context, b *bot.Bot, update *models.Update) {
+	user := &update.CallbackQuery.From
+	if !auth.CanExecute(user.ID, p.prefix, CB_REFRESH) {
+		b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: update.CallbackQuery.ID,
+			Text:            "action is not permitted",
+			ShowAlert:       true,
+		})
+		return
+	}
+	b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: update.CallbackQuery.ID,
+		Text:            CB_REFRESH,
+		ShowAlert:       false,
+	})
+	p.refresher.Refresh()
+	p.Locked(func() {
+		p.userID = user.ID
+		p.user = auth.Name(user)
+		p.show()
+		p.save()
+	})
 }
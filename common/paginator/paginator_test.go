@@ -0,0 +1,292 @@
+package paginator
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"torrentino/common"
+	"torrentino/fakes/telegram"
+)
+
+const testUser = 1
+
+func newTestBot(t *testing.T) *bot.Bot {
+	srv := telegram.NewServer()
+	t.Cleanup(srv.Close)
+	b, err := srv.NewBot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+type testPaginator struct {
+	Paginator
+}
+
+func newTestPaginator(ctx context.Context, b *bot.Bot) *testPaginator {
+	var p testPaginator
+	update := &models.Update{Message: &models.Message{ID: 1, Chat: models.Chat{ID: 1}, From: &models.User{ID: testUser}}}
+	p = testPaginator{
+		*New(ctx, b, update, "test", 4, &p, &p, &p),
+	}
+	p.SetupSorting([]Sorting{{Attribute: "Value", Alias: "value", Order: 1}})
+	p.SetupFiltering([]string{"Parity"})
+	return &p
+}
+
+func (p *testPaginator) Item(i int) int {
+	return *p.Paginator.Item(i).(*int)
+}
+
+// Identifier
+func (p *testPaginator) ID(i int) string {
+	return strconv.Itoa(p.Item(i))
+}
+
+func (p *testPaginator) Line(i int) string {
+	return strconv.Itoa(p.Item(i))
+}
+
+func (p *testPaginator) Actions(i int) []string {
+	return []string{"delete"}
+}
+
+func (p *testPaginator) Execute(i int, action string) bool {
+	p.Delete(i)
+	return true
+}
+
+func (p *testPaginator) Stringify(i int, attribute string) string {
+	return []string{"even", "odd"}[p.Item(i)%2]
+}
+
+func (p *testPaginator) Compare(i int, j int, attribute string) bool {
+	return p.Item(i) < p.Item(j)
+}
+
+func (p *testPaginator) reload(n int) {
+	items := make([]int, n)
+	p.Locked(func() {
+		p.Alloc(n)
+		for i := range items {
+			items[i] = i
+			p.Append(&items[i])
+		}
+	})
+}
+
+func newShownPaginator(h *telegram.Harness, n int) *testPaginator {
+	p := newTestPaginator(h.Ctx, h.Bot)
+	p.update = h.TextUpdate(h.ChatID, h.UserID, "test")
+	p.reload(n)
+	p.Show()
+	return p
+}
+
+func callback(data string) *models.Update {
+	return &models.Update{CallbackQuery: &models.CallbackQuery{ID: "1", From: models.User{ID: testUser}, Data: data}}
+}
+
+// the auto refreshing view (like downloads.Updater) and user presses must not race or panic
+func TestConcurrentReloadAndCallbacks(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	ctx := context.Background()
+	b := newTestBot(t)
+	p := newTestPaginator(ctx, b)
+	p.reload(10)
+	p.Show()
+
+	commands := []string{
+		CB_NEXT_PAGE, CB_PREV_PAGE, CB_TOGGLE_FILTERS,
+		CB_ORDER_BY + "Value", CB_FILTER_BY + "Parity/even", CB_FILTER_BY + "Parity/odd",
+		CB_ACTION + "delete", CB_ACTION + "delete",
+	}
+	for i := range 12 {
+		commands = append(commands, strconv.Itoa(i))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			p.reload(rand.Intn(13))
+			p.Show()
+		}
+	}()
+	for range 2 {
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				p.callbackHandler(ctx, b, callback("test"+commands[rand.Intn(len(commands))]))
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.Locked(func() {
+		if p.selectedItem >= p.Len() {
+			t.Errorf("selected item %d is out of the list of %d", p.selectedItem, p.Len())
+		}
+		if p.activePage > 0 && p.activePage*p.itemsPerPage >= p.Len() {
+			t.Errorf("active page %d is out of the list of %d", p.activePage, p.Len())
+		}
+	})
+}
+
+func TestDeleteSelectedAfterShrink(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	ctx := context.Background()
+	b := newTestBot(t)
+	p := newTestPaginator(ctx, b)
+	p.reload(10)
+	p.Show()
+
+	p.callbackHandler(ctx, b, callback("test"+CB_NEXT_PAGE))
+	p.callbackHandler(ctx, b, callback("test"+CB_NEXT_PAGE))
+	p.callbackHandler(ctx, b, callback("test6")) // item 3
+	p.reload(3)                                  // items 2 1 0
+	p.callbackHandler(ctx, b, callback("test"+CB_ACTION+"delete"))
+
+	p.Locked(func() {
+		if p.Len() != 3 {
+			t.Errorf("expected nothing deleted, got %d items", p.Len())
+		}
+		if p.activePage != 0 || p.selectedItem != -1 {
+			t.Errorf("expected first page and no selection, got page %d, item %d", p.activePage, p.selectedItem)
+		}
+	})
+}
+
+func TestRenderAndNavigate(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	h := telegram.NewHarness(t, 1, testUser)
+	newShownPaginator(h, 6)
+
+	m := h.Last()
+	if !strings.Contains(m.Text, "results: 1-4 of 6") {
+		t.Errorf("unexpected header in %q", m.Text)
+	}
+	if got := strings.Join(m.Buttons(), " "); got != "1 2 3 4 - 🔻 ➡" {
+		t.Errorf("unexpected keyboard %q", got)
+	}
+
+	h.Press(m, "➡")
+	m = h.Last()
+	if !strings.Contains(m.Text, "results: 5-6 of 6") {
+		t.Errorf("unexpected header after next page in %q", m.Text)
+	}
+
+	h.Press(m, "5")
+	m = h.Last()
+	if _, ok := m.Button("delete"); !ok {
+		t.Errorf("expected action buttons for selected item, got %v", m.Buttons())
+	}
+	h.Press(m, "delete")
+	if m = h.Last(); !strings.Contains(m.Text, "results: 5-5 of 5") {
+		t.Errorf("unexpected header after delete in %q", m.Text)
+	}
+	if len(h.Messages(h.ChatID)) != 1 {
+		t.Errorf("expected the list to be edited in place")
+	}
+}
+
+func TestForeignMessageCallback(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	h := telegram.NewHarness(t, 1, testUser)
+	p := newShownPaginator(h, 6)
+	m := h.Last()
+
+	h.ChatID = 2 // same data pressed in another chat must not drive the paginator
+	h.PressData(m.ID, "test"+CB_NEXT_PAGE)
+	answers := h.Answers()
+	if len(answers) != 1 || !answers[0].ShowAlert {
+		t.Errorf("expected alert, got %v", answers)
+	}
+	p.Locked(func() {
+		if p.activePage != 0 {
+			t.Errorf("page changed by a foreign callback")
+		}
+	})
+}
+
+// slowPaginator refreshes until released
+type slowPaginator struct {
+	testPaginator
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *slowPaginator) Refresh() {
+	close(p.started)
+	<-p.release
+	p.reload(8)
+}
+
+func TestRefresh(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	h := telegram.NewHarness(t, 1, testUser)
+	var p slowPaginator
+	p = slowPaginator{
+		testPaginator{*New(h.Ctx, h.Bot, h.TextUpdate(h.ChatID, h.UserID, "test"), "test", 4, &p, &p, &p)},
+		make(chan struct{}),
+		make(chan struct{}),
+	}
+	p.reload(6)
+	p.Show()
+	m := h.Last()
+	if got := strings.Join(m.Buttons(), " "); got != "1 2 3 4 - 🔻 🔄 ➡" {
+		t.Errorf("unexpected keyboard %q", got)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.callbackHandler(h.Ctx, h.Bot, callback("test"+CB_REFRESH))
+	}()
+	<-p.started
+	p.callbackHandler(h.Ctx, h.Bot, callback("test"+CB_NEXT_PAGE)) // must not wait for the refresh
+	if m = h.Last(); !strings.Contains(m.Text, "results: 5-6 of 6") {
+		t.Errorf("unexpected header while refreshing in %q", m.Text)
+	}
+	close(p.release)
+	<-done
+	if m = h.Last(); !strings.Contains(m.Text, "results: 5-8 of 8") || len(h.Messages(h.ChatID)) != 1 {
+		t.Errorf("the refreshed list must be edited in place, got %q", m.Text)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	h := telegram.NewHarness(t, 1, testUser)
+	idle := newShownPaginator(h, 6)
+	deadline := time.Now()
+	active := newShownPaginator(h, 2)
+	messages := h.Messages(h.ChatID)
+
+	Expire(deadline) // as Collect does after "session-ttl" of idleness
+	if idle.Context().Err() == nil || active.Context().Err() != nil {
+		t.Fatal("only the session idle since before the deadline must expire")
+	}
+	m := h.Messages(h.ChatID)[0]
+	if len(m.Keyboard) != 0 || !strings.Contains(m.Text, "⌛ expired, run the command again") {
+		t.Errorf("expired list must lose its keyboard, got %q %v", m.Text, m.Buttons())
+	}
+	if m = h.Messages(h.ChatID)[1]; len(m.Keyboard) == 0 {
+		t.Errorf("active list must keep its keyboard")
+	}
+
+	h.PressData(messages[0].ID, "test"+CB_NEXT_PAGE)
+	if answers := h.Answers(); len(answers) != 1 || answers[0].Text != "this list is no longer active, run the command again" {
+		t.Errorf("expected the session to be gone, got %v", answers)
+	}
+}
@@ -0,0 +1,181 @@
+package paginator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"torrentino/common"
+	"torrentino/common/utils"
+)
+
+const (
+	DEFAULT_SESSION_TTL = 60 * time.Minute
+	GC_INTERVAL         = time.Minute
+)
+
+// every shown paginator is a session, identified by the message carrying its keyboard
+type SessionKey struct {
+	ChatID    int64
+	MessageID int
+}
+
+type handlerKey struct {
+	bot    *bot.Bot
+	prefix string
+}
+
+var sessions = struct {
+	sync.Mutex
+	store    map[SessionKey]*Paginator
+	handlers map[handlerKey]string // callback handler id
+}{
+	store:    make(map[SessionKey]*Paginator),
+	handlers: make(map[handlerKey]string),
+}
+
+// route callbacks with the prefix to the registry, must be called under sessions lock
+func routeCallbacks(b *bot.Bot, prefix string) {
+	key := handlerKey{b, prefix}
+	if _, ok := sessions.handlers[key]; !ok {
+		sessions.handlers[key] = b.RegisterHandler(bot.HandlerTypeCallbackQueryData, prefix, bot.MatchTypePrefix, dispatch)
+	}
+}
+
+func keyOf(message *models.MaybeInaccessibleMessage) (key SessionKey, ok bool) {
+	switch message.Type {
+	case models.MaybeInaccessibleMessageTypeMessage:
+		if message.Message != nil {
+			return SessionKey{message.Message.Chat.ID, message.Message.ID}, true
+		}
+	case models.MaybeInaccessibleMessageTypeInaccessibleMessage:
+		if message.InaccessibleMessage != nil {
+			return SessionKey{message.InaccessibleMessage.Chat.ID, message.InaccessibleMessage.MessageID}, true
+		}
+	}
+	return key, false
+}
+
+// register the session and make sure the callbacks with its prefix are routed to the registry
+func register(p *Paginator) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	p.ctx, p.cancel = context.WithCancel(p.ctx)
+	p.lastActive = time.Now()
+	sessions.store[SessionKey{p.message.Chat.ID, p.message.ID}] = p
+	p.save()
+	routeCallbacks(p.bot, p.prefix)
+}
+
+// lookup returns the session and prolongs its life
+func lookup(key SessionKey) *Paginator {
+	sessions.Lock()
+	defer sessions.Unlock()
+	p, ok := sessions.store[key]
+	if ok {
+		p.lastActive = time.Now()
+	}
+	return p
+}
+
+func sessionTTL() time.Duration {
+	if value := common.Current().SessionTTL; value > 0 {
+		return time.Duration(value) * time.Minute
+	}
+	return DEFAULT_SESSION_TTL
+}
+
+// Collect expires sessions idle longer than "session-ttl" minutes, runs until ctx is done
+func Collect(ctx context.Context) {
+	ticker := time.NewTicker(GC_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			Expire(time.Now().Add(-sessionTTL()))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Expire ends the sessions idle since before deadline and purges the stored ones
+func Expire(deadline time.Time) {
+	var expired []*Paginator
+	sessions.Lock()
+	for key, p := range sessions.store {
+		if p.lastActive.Before(deadline) {
+			expired = append(expired, p)
+			delete(sessions.store, key)
+		}
+	}
+	sessions.Unlock()
+	for _, p := range expired {
+		p.expire()
+	}
+	if Storage != nil {
+		if err := Storage.Purge(deadline); err != nil {
+			utils.LogError(err)
+		}
+	}
+}
+
+// expire stops the session jobs and strips the keyboard off its message
+func (p *Paginator) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancel()
+	if Storage != nil {
+		if err := Storage.Delete(SessionKey{p.message.Chat.ID, p.message.ID}); err != nil {
+			utils.LogError(err)
+		}
+	}
+	_, err := p.bot.EditMessageText(context.WithoutCancel(p.ctx), &bot.EditMessageTextParams{
+		ChatID:    p.message.Chat.ID,
+		MessageID: p.message.ID,
+		Text:      p.text + "\n\n<i>⌛ expired, run the command again</i>",
+		ParseMode: models.ParseModeHTML,
+	})
+	if err != nil {
+		utils.LogError(err)
+	}
+}
+
+// dispatch routes the callback to the paginator which owns the pressed keyboard
+func dispatch(ctx context.Context, b *bot.Bot, update *models.Update) {
+	var p *Paginator
+	var err error
+	key, ok := keyOf(&update.CallbackQuery.Message)
+	if ok {
+		if p = lookup(key); p == nil {
+			p, err = restore(ctx, b, key)
+		}
+	}
+	if err != nil {
+		utils.LogError(err)
+		_, err = b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: update.CallbackQuery.ID,
+			Text:            "unable to restore the list: " + err.Error(),
+			ShowAlert:       true,
+		})
+		if err != nil {
+			utils.LogError(err)
+		}
+		return
+	}
+	if p == nil {
+		_, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+			CallbackQueryID: update.CallbackQuery.ID,
+			Text:            "this list is no longer active, run the command again",
+			ShowAlert:       true,
+		})
+		if err != nil {
+			utils.LogError(err)
+		}
+		return
+	}
+	p.callbackHandler(ctx, b, update)
+}
@@ -0,0 +1,194 @@
+package paginator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
+
+	"torrentino/common/utils"
+)
+
+// State is everything needed to rehydrate a session after restart, the list itself is reloaded
+type State struct {
+	Prefix      string
+	ChatID      int64
+	MessageID   int
+	UserID      int64
+	Query       string
+	ActivePage  int
+	Selected    string // identity of the selected item, see Identifier
+	ExtControls bool
+	Orders      map[string]int8     // sorting attribute -> order
+	Queue       []string            // sorting queue
+	Filters     map[string][]string // filtering attribute -> enabled values
+	LastActive  time.Time
+}
+
+type Store interface {
+	Save(key SessionKey, state *State) error
+	Load(key SessionKey) (*State, error) // nil state, if not found
+	Delete(key SessionKey) error
+	Purge(before time.Time) error
+}
+
+// Storage keeps sessions across restarts, nil disables persistence
+var Storage Store
+
+// Querier is implemented by paginators built upon user input, the query is saved along with the session
+type Querier interface {
+	Query() string
+}
+
+// Restorer rebuilds the paginator of given prefix, reloads it and applies the state with Paginator.Restore
+type Restorer func(ctx context.Context, b *bot.Bot, state *State) (*Paginator, error)
+
+var restorers = make(map[string]Restorer)
+
+// RegisterRestorer makes the sessions with this prefix survive restarts
+func RegisterRestorer(b *bot.Bot, prefix string, restorer Restorer) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	restorers[prefix] = restorer
+	routeCallbacks(b, prefix)
+}
+
+func (p *Paginator) state() *State {
+	state := &State{
+		Prefix:      p.prefix,
+		ChatID:      p.message.Chat.ID,
+		MessageID:   p.message.ID,
+		UserID:      p.userID,
+		ActivePage:  p.activePage,
+		Selected:    p.selectedID,
+		ExtControls: p.extControls,
+		LastActive:  time.Now(),
+	}
+	if q, ok := p.Actor.(Querier); ok {
+		state.Query = q.Query()
+	}
+	state.Orders, state.Queue = p.sortingState()
+	state.Filters = p.filtersState()
+	return state
+}
+
+func (p *Paginator) save() {
+	if Storage == nil || p.message == nil {
+		return
+	}
+	state := p.state()
+	if err := Storage.Save(SessionKey{state.ChatID, state.MessageID}, state); err != nil {
+		utils.LogError(err)
+	}
+}
+
+// Restore applies the saved state to the reloaded paginator and registers it as a live session
+func (p *Paginator) Restore(state *State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.message = &models.Message{ID: state.MessageID, Chat: models.Chat{ID: state.ChatID}}
+	p.userID = state.UserID
+	p.extControls = state.ExtControls
+	p.restoreSorting(state.Orders, state.Queue)
+	p.restoreFilters(state.Filters)
+	p.Filter()
+	p.Sort()
+	p.activePage = state.ActivePage
+	p.selectedID = state.Selected // the items have moved since, the ones without identity are not selected again
+	p.follow()
+	p.clamp()
+	register(p)
+}
+
+// restore rehydrates the session from Storage, nil if there is nothing to restore
+func restore(ctx context.Context, b *bot.Bot, key SessionKey) (*Paginator, error) {
+	if Storage == nil {
+		return nil, nil
+	}
+	state, err := Storage.Load(key)
+	if err != nil || state == nil {
+		return nil, err
+	}
+	if state.LastActive.Before(time.Now().Add(-sessionTTL())) {
+		return nil, Storage.Delete(key)
+	}
+	restorer, ok := restorers[state.Prefix]
+	if !ok {
+		return nil, nil
+	}
+	return restorer(ctx, b, state)
+}
+
+// ----------------------------------------
+// FileStore keeps every session in a separate json file
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "NewFileStore")
+	}
+	return &FileStore{dir}, nil
+}
+
+func (fs *FileStore) fileName(key SessionKey) string {
+	return path.Join(fs.dir, fmt.Sprintf("%d_%d.json", key.ChatID, key.MessageID))
+}
+
+func (fs *FileStore) Save(key SessionKey, state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "FileStore.Save")
+	}
+	tmp := fs.fileName(key) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return errors.Wrap(err, "FileStore.Save")
+	}
+	return errors.Wrap(os.Rename(tmp, fs.fileName(key)), "FileStore.Save")
+}
+
+func (fs *FileStore) Load(key SessionKey) (*State, error) {
+	data, err := os.ReadFile(fs.fileName(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "FileStore.Load")
+	}
+	var state State
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "FileStore.Load")
+	}
+	return &state, nil
+}
+
+func (fs *FileStore) Delete(key SessionKey) error {
+	err := os.Remove(fs.fileName(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return errors.Wrap(err, "FileStore.Delete")
+}
+
+func (fs *FileStore) Purge(before time.Time) error {
+	dir, err := utils.ReadDir(fs.dir, false)
+	if err != nil {
+		return errors.Wrap(err, "FileStore.Purge")
+	}
+	for entry := range dir {
+		if !entry.IsDir && strings.HasSuffix(entry.Name, ".json") && entry.ModTime.Before(before) {
+			if err = os.Remove(path.Join(fs.dir, entry.Name)); err != nil {
+				utils.LogError(err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package paginator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+
+	"torrentino/common"
+	"torrentino/fakes/telegram"
+)
+
+func TestFileStore(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := SessionKey{ChatID: -100, MessageID: 7}
+	state := &State{
+		Prefix: "list", ChatID: key.ChatID, MessageID: key.MessageID, UserID: 1, Query: "ubuntu",
+		ActivePage: 1, Selected: "transmission/abc", Orders: map[string]int8{"Name": 2}, Queue: []string{"Name"},
+		Filters: map[string][]string{"Status": {"seeding"}}, LastActive: time.Now().Round(0),
+	}
+	if err = fs.Save(key, state); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := fs.Load(key)
+	if err != nil || !loaded.LastActive.Equal(state.LastActive) {
+		t.Fatalf("expected %+v, got %+v %v", state, loaded, err)
+	}
+	loaded.LastActive = state.LastActive
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("expected %+v, got %+v", state, loaded)
+	}
+	if loaded, err = fs.Load(SessionKey{ChatID: 1, MessageID: 1}); loaded != nil || err != nil {
+		t.Errorf("missing session must be nil without error, got %+v %v", loaded, err)
+	}
+
+	if err = fs.Purge(time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ = fs.Load(key); loaded == nil {
+		t.Error("the session saved after the deadline must not be purged")
+	}
+	if err = fs.Purge(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ = fs.Load(key); loaded != nil {
+		t.Error("the session saved before the deadline must be purged")
+	}
+	if err = fs.Delete(key); err != nil {
+		t.Errorf("deleting missing session must not fail, got %v", err)
+	}
+}
+
+// restart forgets the live sessions, as if the bot has been started again
+func restart() {
+	sessions.Lock()
+	defer sessions.Unlock()
+	clear(sessions.store)
+}
+
+func TestRestoreAfterRestart(t *testing.T) {
+	common.Settings.UsersList = []int64{testUser}
+	var err error
+	if Storage, err = NewFileStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Storage = nil })
+	h := telegram.NewHarness(t, 1, testUser)
+	newShownPaginator(h, 6) // 5 4 3 2 1 0
+	m := h.Last()
+	h.Press(m, "2")
+	if selected := h.Last(); !strings.Contains(selected.Text, "<u>4</u>") {
+		t.Fatalf("expected the second item selected, got %q", selected.Text)
+	}
+
+	restart()
+	var restored *testPaginator
+	RegisterRestorer(h.Bot, "test", func(ctx context.Context, b *bot.Bot, state *State) (*Paginator, error) {
+		restored = newTestPaginator(ctx, b)
+		restored.reload(7) // a newer item has appeared while the bot was down: 6 5 4 3 2 1 0
+		restored.Restore(state)
+		return &restored.Paginator, nil
+	})
+	h.PressData(m.ID, "test"+CB_ACTION+"delete")
+	if restored == nil {
+		t.Fatal("the session is not restored")
+	}
+	if m = h.Last(); !strings.Contains(m.Text, "results: 1-4 of 6") || !strings.Contains(m.Text, "<b>2.</b> 5\n") {
+		t.Errorf("the item selected before restart must be deleted, got %q", m.Text)
+	}
+}
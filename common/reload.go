@@ -0,0 +1,81 @@
+package common
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// WatchInterval is how often the settings file is checked for changes
+var WatchInterval = 2 * time.Second
+
+// Watch reloads the settings on SIGHUP or when the file changes, until ctx is done. The file has to stay
+// unchanged for one more interval before it's read, so half-saved files are not picked. report gets the
+// result of every attempt: the error (the settings stay intact then) or the changed settings that
+// are only applied on restart
+func Watch(ctx context.Context, fileName string, report func(err error, restart []string)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
+	name := cmp.Or(fileName, DEFAULT_FILE)
+	last, pending := stamp(name), false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			current := stamp(name)
+			if current != last {
+				last, pending = current, true
+				continue
+			}
+			if !pending {
+				continue
+			}
+		}
+		pending = false
+		before := Current()
+		if err := Load(fileName); err != nil {
+			report(err, nil)
+			continue
+		}
+		report(nil, restartRequired(before, Current()))
+	}
+}
+
+func stamp(name string) string {
+	info, err := os.Stat(name)
+	if err != nil {
+		return ""
+	}
+	return info.ModTime().String() + " " + strconv.FormatInt(info.Size(), 10)
+}
+
+// restartRequired lists the changed settings which are taken once on startup: connections and stores
+func restartRequired(before, after *SettingsStruct) (result []string) {
+	for _, setting := range []struct {
+		key           string
+		before, after any
+	}{
+		{"telegram-api-token", before.TelegramAPIToken, after.TelegramAPIToken},
+		{"jackett", [3]any{before.Jackett.Host, before.Jackett.Port, before.Jackett.APIKey}, [3]any{after.Jackett.Host, after.Jackett.Port, after.Jackett.APIKey}},
+		{"torrserver", before.Torrserver, after.Torrserver},
+		{"download-clients", before.Clients(), after.Clients()},
+		{"session-store", before.SessionStore, after.SessionStore},
+		{"owners-store", before.OwnersStore, after.OwnersStore},
+	} {
+		if !reflect.DeepEqual(setting.before, setting.after) {
+			result = append(result, setting.key)
+		}
+	}
+	return result
+}
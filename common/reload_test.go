@@ -0,0 +1,95 @@
+package common_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"torrentino/common"
+)
+
+type result struct {
+	err     error
+	restart []string
+}
+
+func watch(t *testing.T, file string) chan result {
+	before, interval := common.Settings, common.WatchInterval
+	common.WatchInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan result, 10)
+	done := make(chan struct{})
+	go func() {
+		common.Watch(ctx, file, func(err error, restart []string) { results <- result{err, restart} })
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		common.Settings, common.WatchInterval = before, interval
+	})
+	time.Sleep(5 * common.WatchInterval) // let it take the initial stamp
+	return results
+}
+
+func next(t *testing.T, results chan result) result {
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("settings are not reloaded")
+	}
+	return result{}
+}
+
+func TestWatch(t *testing.T) {
+	file := path.Join(t.TempDir(), common.DEFAULT_FILE)
+	settings := valid(t)
+	os.WriteFile(file, []byte(settings), 0o600)
+	if err := common.Load(file); err != nil {
+		t.Fatal(err)
+	}
+	results := watch(t, file)
+
+	os.WriteFile(file, []byte(strings.Replace(settings, `"rutor"`, `"rutor", "kinozal"`, 1)), 0o600)
+	if r := next(t, results); r.err != nil || len(r.restart) != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if indexers := common.Current().Jackett.Indexers; !slices.Equal(indexers, []string{"rutor", "kinozal"}) {
+		t.Errorf("indexers are not reloaded: %v", indexers)
+	}
+
+	os.WriteFile(file, []byte(`{ "telegram-api-token" : "" }`), 0o600)
+	if r := next(t, results); r.err == nil {
+		t.Error("invalid settings must be reported")
+	}
+	if indexers := common.Current().Jackett.Indexers; len(indexers) != 2 {
+		t.Errorf("invalid settings must not be applied, got %v", indexers)
+	}
+
+	os.WriteFile(file, []byte(strings.Replace(settings, `"api-key" : "key"`, `"api-key" : "other"`, 1)), 0o600)
+	if r := next(t, results); r.err != nil || !slices.Equal(r.restart, []string{"jackett"}) {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestWatchSignal(t *testing.T) {
+	file := path.Join(t.TempDir(), common.DEFAULT_FILE)
+	os.WriteFile(file, []byte(valid(t)), 0o600)
+	users := common.Settings.UsersList
+	t.Cleanup(func() { common.Settings.UsersList = users })
+	common.Settings.UsersList = nil
+	results := watch(t, file)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if r := next(t, results); r.err != nil {
+		t.Error(r.err)
+	}
+	if users := common.Current().UsersList; !slices.Equal(users, []int64{1}) {
+		t.Errorf("settings are not reloaded on SIGHUP: %v", users)
+	}
+}
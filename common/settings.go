@@ -1,48 +1,213 @@
 package common
 
 import (
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
 	"os"
-
-	"torrentino/common/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
 
-type hostPort struct {
+type HostPort struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
 }
 
+type Role struct {
+	Commands []string `json:"commands"` // "/downloads", "/torrserver", "search" or "*"
+	Actions  []string `json:"actions"`  // "list:delete", "find:*", "torrsrv" or "*"
+}
+
+const RoleAdmin = "admin"
+
+// Commands are the ones roles may allow besides "*"
+var Commands = []string{"search", "/downloads", "/torrserver", "/status"}
+
+// DefaultRoles are used unless redefined in settings
+var DefaultRoles = map[string]Role{
+	RoleAdmin: {
+		Commands: []string{"*"},
+		Actions:  []string{"*"},
+	},
+	"downloader": {
+		Commands: []string{"*"},
+		Actions:  []string{"find:*", "list:start", "list:pause", "list:files", "files:*", "pick:*", "torrserver:*", "status:*"},
+	},
+	"viewer": {
+		Commands: []string{"*"},
+		Actions:  []string{"find:web page", "find:.torrent", "status:refresh"},
+	},
+}
+
+type DownloadClient struct {
+	Name         string `json:"name"` // label of the instance in /downloads and search actions
+	Type         string `json:"type"` // "transmission" (default) or "qbittorrent"
+	HostPort     `json:",inline"`
+	URL          string `json:"url"`      // full endpoint, e.g. "https://nas.example.com/transmission/rpc", instead of host and port
+	HTTPS        bool   `json:"https"`    // with host and port
+	RPCPath      string `json:"rpc-path"` // with host and port, "/transmission/rpc" by default
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password-file"` // read the password from the file, e.g. docker secret
+	CAFile       string `json:"ca-file"`       // PEM certificates trusted in addition to the system ones, e.g. private CA of NAS
+}
+
+// Endpoint returns "url" or the one made of host, port, "https" and "rpc-path"
+func (c *DownloadClient) Endpoint(defaultPort int, defaultPath string) string {
+	if c.URL != "" {
+		return c.URL
+	}
+	u := url.URL{Scheme: "http", Host: c.Host, Path: c.RPCPath}
+	if c.HTTPS {
+		u.Scheme = "https"
+	}
+	port := c.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	if port != 0 {
+		u.Host = net.JoinHostPort(c.Host, strconv.Itoa(port))
+	}
+	if u.Path == "" {
+		u.Path = defaultPath
+	}
+	return u.String()
+}
+
+// Secret returns "password" or the content of "password-file" without trailing newline
+func (c *DownloadClient) Secret() (string, error) {
+	if c.PasswordFile == "" {
+		return c.Password, nil
+	}
+	data, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", errors.Wrap(err, "password-file")
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// TLSConfig trusts the certificates of "ca-file" as well as the system ones, nil if it's not set
+func (c *DownloadClient) TLSConfig() (*tls.Config, error) {
+	if c.CAFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "ca-file")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("ca-file: no certificates in " + c.CAFile)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+type Category struct {
+	Name           string   `json:"name"` // the first category is the plain "download" action, the others are "download:<name>"
+	Path           string   `json:"path"`
+	Icon           string   `json:"icon"`
+	Labels         []string `json:"labels"`          // Transmission labels, the first one is qBittorrent category
+	BandwidthGroup string   `json:"bandwidth-group"` // Transmission 4 bandwidth group
+}
+
+type Jackett struct {
+	HostPort `json:",inline"`
+	APIKey   string   `json:"api-key"`
+	Indexers []string `json:"indexers"`
+}
+
 type SettingsStruct struct {
-	Jackett struct {
-		hostPort `json:",inline"`
-		APIKey   string   `json:"api-key"`
-		Indexers []string `json:"indexers"`
-	} `json:"jackett"`
+	Jackett Jackett `json:"jackett"`
 
-	Transmission hostPort `json:",inline"`
-	Torrserver   hostPort `json:",inline"`
+	DownloadClients []DownloadClient `json:"download-clients"`
+	DownloadClient  string           `json:"download-client"` // single instance: "transmission" (default) or "qbittorrent"
+	Transmission    DownloadClient   `json:"transmission"`
+	Qbittorrent     DownloadClient   `json:"qbittorrent"`
+	Torrserver      HostPort         `json:",inline"`
 
 	TelegramAPIToken string  `json:"telegram-api-token"`
 	UsersList        []int64 `json:"users-list"`
 
-	Path struct {
+	SessionTTL   int    `json:"session-ttl"`   // minutes of inactivity before a list stops responding
+	SessionStore string `json:"session-store"` // directory to keep lists state across restarts
+	OwnersStore  string `json:"owners-store"`  // file to remember who added which torrent, "owners.json" by default
+
+	NotifyInterval int `json:"notify-interval"` // seconds between checks of the downloads to notify about
+	StallTimeout   int `json:"stall-timeout"`   // minutes without progress before a download is reported as stalled
+
+	Roles     map[string]Role  `json:"roles"`
+	UserRoles map[int64]string `json:"user-roles"`
+
+	CategoryList []Category `json:"categories"`
+	Path         struct {   // legacy categories, used when "categories" is empty
 		Default string `json:"default"`
 		Movie   string `json:"movie"`
 		Series  string `json:"series"`
 	} `json:"path"`
 }
 
+// Settings is written only by Load (and tests before the bot starts), readers use Current
 var Settings SettingsStruct
 
-func init() {
-	data, err := os.ReadFile("./settings.json")
-	if err != nil {
-		utils.LogError(errors.Wrap(err, "readFile"))
+var settingsMu sync.RWMutex
+
+// Current returns a snapshot of the settings, it stays consistent while the settings are reloaded
+func Current() *SettingsStruct {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	s := Settings
+	return &s
+}
+
+// Clients returns "download-clients", or the single instance described by "download-client" when the list is empty
+func (s *SettingsStruct) Clients() []DownloadClient {
+	if len(s.DownloadClients) > 0 {
+		clients := slices.Clone(s.DownloadClients)
+		for i := range clients {
+			if clients[i].Type == "" {
+				clients[i].Type = "transmission"
+			}
+			if clients[i].Name == "" {
+				clients[i].Name = clients[i].Type
+			}
+		}
+		return clients
 	}
-	err = json.Unmarshal(data, &Settings)
-	if err != nil {
-		utils.LogError(err)
+	var single DownloadClient
+	switch s.DownloadClient {
+	case "qbittorrent":
+		single = s.Qbittorrent
+	default:
+		single = s.Transmission
+	}
+	single.Type = s.DownloadClient
+	if single.Type == "" {
+		single.Type = "transmission"
+	}
+	single.Name = single.Type
+	return []DownloadClient{single}
+}
+
+// Categories returns "categories", or the ones made of "path" when the list is empty
+func (s *SettingsStruct) Categories() []Category {
+	if len(s.CategoryList) > 0 {
+		return s.CategoryList
+	}
+	categories := []Category{{Name: "default", Path: s.Path.Default, Icon: "📥"}}
+	if s.Path.Series != "" {
+		categories = append(categories, Category{Name: "series", Path: s.Path.Series, Icon: "📺"})
+	}
+	if s.Path.Movie != "" {
+		categories = append(categories, Category{Name: "movie", Path: s.Path.Movie, Icon: "🎬"})
 	}
+	return categories
 }
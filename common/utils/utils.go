@@ -1,14 +1,17 @@
 package utils
 
 import (
-	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -33,6 +36,16 @@ func FormatFileSize(size uint64) string {
 	return fmt.Sprintf(f, fs, sizes[i])
 }
 
+// DiskUsage returns used and free space of the volume the path is on
+func DiskUsage(path string) (used uint64, free uint64, err error) {
+	fs := syscall.Statfs_t{}
+	if err = syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	free = fs.Bfree * uint64(fs.Bsize)
+	return fs.Blocks*uint64(fs.Bsize) - free, free, nil
+}
+
 func Timestamp() string {
 	return strconv.FormatInt(time.Now().Unix(), 10)
 }
@@ -92,28 +105,90 @@ func ReadDir(rootPath string, recursive bool) (<-chan FileInfo, error) {
 	return channel, err
 }
 
-func LogError(err error) {
-	pc, file, line, _ := runtime.Caller(1)
-	_, fileName := path.Split(file)
-	parts := strings.Split(runtime.FuncForPC(pc).Name(), ".")
-	funcName := strings.Join(parts[1:], ".")
-	log.Printf("[%s/%s(%s)] %s: %s", parts[0][11:], fileName, strconv.Itoa(line), funcName, err)
+// MoveAll renames the file or directory, across file systems the data is copied and then removed,
+// progress is called with the bytes copied so far
+func MoveAll(src string, dst string, progress func(done int64, total int64)) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return errors.New(dst + " already exists")
+	}
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if progress == nil {
+		progress = func(int64, int64) {}
+	}
+	var total, done int64
+	err = filepath.WalkDir(src, func(name string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			var info fs.FileInfo
+			if info, err = entry.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(src, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, strings.TrimPrefix(name, src))
+		info, err := entry.Info()
+		switch {
+		case err != nil:
+			return err
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return copyFile(name, target, info.Mode().Perm(), func(n int64) {
+			done += n
+			progress(done, total)
+		})
+	})
+	if err != nil {
+		os.RemoveAll(dst) // the source is intact, don't leave a partial copy
+		return err
+	}
+	return os.RemoveAll(src)
 }
 
-func WithTimeout(cbfn func() error, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	done := make(chan error, 1)
+type progressWriter func(n int64)
 
-	go func() {
-		done <- cbfn()
-	}()
+func (w progressWriter) Write(p []byte) (int, error) {
+	w(int64(len(p)))
+	return len(p), nil
+}
 
-	select {
-	case err := <-done:
+func copyFile(src string, dst string, mode fs.FileMode, progress func(n int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
 		return err
-	case <-ctx.Done():
-		return errors.Wrap(ctx.Err(), "operation timeout")
 	}
+	if _, err = io.Copy(io.MultiWriter(out, progressWriter(progress)), in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
 
+func LogError(err error) {
+	pc, file, line, _ := runtime.Caller(1)
+	_, fileName := path.Split(file)
+	parts := strings.Split(runtime.FuncForPC(pc).Name(), ".")
+	funcName := strings.Join(parts[1:], ".")
+	log.Printf("[%s/%s(%s)] %s: %s", parts[0][11:], fileName, strconv.Itoa(line), funcName, err)
 }
@@ -0,0 +1,244 @@
+package common
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const DEFAULT_FILE = "settings.json"
+
+// SettingsErrors is the report of everything wrong with the settings at once
+type SettingsErrors []string
+
+func (e SettingsErrors) Error() string {
+	return "invalid settings:\n - " + strings.Join(e, "\n - ")
+}
+
+// Load reads the settings file, overrides it by the environment, checks and applies the result,
+// Settings stay intact on any error. Empty name is DEFAULT_FILE, which may be absent if the
+// environment provides everything
+func Load(fileName string) error {
+	data, err := os.ReadFile(cmp.Or(fileName, DEFAULT_FILE))
+	if os.IsNotExist(err) && fileName == "" {
+		data, err = []byte("{}"), nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "settings")
+	}
+	s, err := Parse(data, os.Environ())
+	if err != nil {
+		return err
+	}
+	settingsMu.Lock()
+	Settings = *s
+	settingsMu.Unlock()
+	return nil
+}
+
+// Parse decodes the settings, applies TORRENTINO_* variables from environ and validates the result,
+// unknown keys are errors as they are mostly typos
+func Parse(data []byte, environ []string) (*SettingsStruct, error) {
+	var s SettingsStruct
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, SettingsErrors{err.Error()}
+	}
+	report := SettingsErrors(unknownKeys(data, reflect.TypeFor[SettingsStruct](), ""))
+	report = append(report, s.applyEnv(environ)...)
+	report = append(report, s.Validate()...)
+	if len(report) > 0 {
+		return nil, report
+	}
+	return &s, nil
+}
+
+// jsonFields maps json keys of the struct to the field types, the fields of embedded structs are promoted
+func jsonFields(t reflect.Type, fields map[string]reflect.Type) map[string]reflect.Type {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			jsonFields(f.Type, fields)
+		case f.IsExported():
+			if name == "" {
+				name = f.Name
+			}
+			fields[strings.ToLower(name)] = f.Type // encoding/json matches keys case-insensitively
+		}
+	}
+	return fields
+}
+
+func unknownKeys(data []byte, t reflect.Type, prefix string) (result []string) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return unknownKeys(data, t.Elem(), prefix)
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) == nil {
+			for i, item := range items {
+				result = append(result, unknownKeys(item, t.Elem(), prefix+"["+strconv.Itoa(i)+"]")...)
+			}
+		}
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if json.Unmarshal(data, &items) == nil {
+			for _, key := range sortedKeys(items) {
+				result = append(result, unknownKeys(items[key], t.Elem(), prefix+"."+key)...)
+			}
+		}
+	case reflect.Struct:
+		var items map[string]json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return nil
+		}
+		fields := jsonFields(t, make(map[string]reflect.Type))
+		for _, key := range sortedKeys(items) {
+			name := strings.TrimPrefix(prefix+"."+key, ".")
+			if ft, ok := fields[strings.ToLower(key)]; ok {
+				result = append(result, unknownKeys(items[key], ft, name)...)
+			} else {
+				result = append(result, "unknown key "+name)
+			}
+		}
+	}
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// checkPort accepts no port (0) as well, the default one of the service is used then
+func checkPort(name string, port int) []string {
+	if port < 0 || port > 65535 {
+		return []string{name + ".port " + strconv.Itoa(port) + " is out of range 1-65535"}
+	}
+	return nil
+}
+
+func checkDir(name string, dir string) []string {
+	if dir == "" {
+		return []string{name + " is required"}
+	}
+	info, err := os.Stat(dir)
+	switch {
+	case err != nil:
+		return []string{name + ": " + err.Error()}
+	case !info.IsDir():
+		return []string{name + ": " + dir + " is not a directory"}
+	}
+	return nil
+}
+
+// Validate returns the problems of the settings, nothing if they are fine
+func (s *SettingsStruct) Validate() (report []string) {
+	if s.TelegramAPIToken == "" {
+		report = append(report, "telegram-api-token is required")
+	}
+	if s.Jackett.Host == "" {
+		report = append(report, "jackett.host is required")
+	}
+	if s.Jackett.APIKey == "" {
+		report = append(report, "jackett.api-key is required")
+	}
+	report = append(report, checkPort("jackett", s.Jackett.Port)...)
+	report = append(report, checkPort("torrserver", s.Torrserver.Port)...)
+
+	if s.DownloadClient != "" && s.DownloadClient != "transmission" && s.DownloadClient != "qbittorrent" {
+		report = append(report, "download-client "+s.DownloadClient+" is unknown, expected transmission or qbittorrent")
+	}
+	names := make(map[string]bool)
+	for i, c := range s.Clients() {
+		name := "download-clients[" + strconv.Itoa(i) + "]"
+		if len(s.DownloadClients) == 0 {
+			name = c.Type
+		}
+		if c.Type != "transmission" && c.Type != "qbittorrent" {
+			report = append(report, name+".type "+c.Type+" is unknown, expected transmission or qbittorrent")
+		}
+		if names[c.Name] {
+			report = append(report, name+".name "+c.Name+" is not unique")
+		}
+		names[c.Name] = true
+		if c.Host == "" && c.URL == "" {
+			report = append(report, name+": host or url is required")
+		}
+		report = append(report, checkPort(name, c.Port)...)
+		if c.PasswordFile != "" {
+			if _, err := os.Stat(c.PasswordFile); err != nil {
+				report = append(report, name+".password-file: "+err.Error())
+			}
+		}
+		if c.CAFile != "" {
+			if _, err := c.TLSConfig(); err != nil {
+				report = append(report, name+"."+err.Error())
+			}
+		}
+	}
+
+	categories := make(map[string]bool)
+	for i, c := range s.Categories() {
+		name := "categories[" + strconv.Itoa(i) + "]"
+		if len(s.CategoryList) == 0 {
+			name = "path." + c.Name
+		}
+		if c.Name == "" {
+			report = append(report, name+".name is required")
+		} else if categories[c.Name] {
+			report = append(report, name+".name "+c.Name+" is not unique")
+		}
+		categories[c.Name] = true
+		if len(s.CategoryList) > 0 {
+			name += ".path"
+		}
+		report = append(report, checkDir(name, c.Path)...)
+	}
+
+	for _, setting := range []struct {
+		key   string
+		value int
+	}{{"session-ttl", s.SessionTTL}, {"notify-interval", s.NotifyInterval}, {"stall-timeout", s.StallTimeout}} {
+		if setting.value < 0 {
+			report = append(report, setting.key+" must not be negative")
+		}
+	}
+	for _, name := range sortedKeys(s.Roles) {
+		for _, command := range s.Roles[name].Commands {
+			if command != "*" && !slices.Contains(Commands, command) {
+				report = append(report, "roles."+name+".commands: "+command+" is unknown, expected * or "+strings.Join(Commands, ", "))
+			}
+		}
+	}
+	users := make([]int64, 0, len(s.UserRoles))
+	for user := range s.UserRoles {
+		users = append(users, user)
+	}
+	slices.Sort(users)
+	for _, user := range users {
+		if s.UserRoles[user] == "" {
+			report = append(report, "user-roles."+strconv.FormatInt(user, 10)+" has no role")
+			continue
+		}
+		if _, ok := s.Roles[s.UserRoles[user]]; ok {
+			continue
+		}
+		if _, ok := DefaultRoles[s.UserRoles[user]]; !ok {
+			report = append(report, "user-roles."+strconv.FormatInt(user, 10)+": role "+s.UserRoles[user]+" is unknown")
+		}
+	}
+	return report
+}
@@ -0,0 +1,133 @@
+package common_test
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"torrentino/common"
+)
+
+func valid(t *testing.T) string {
+	return `{
+		"jackett" : { "host" : "nas", "port" : 9117, "api-key" : "key", "indexers" : ["rutor"] },
+		"transmission" : { "host" : "nas", "port" : 9091 },
+		"torrserver" : { "host" : "nas", "port" : 8090 },
+		"telegram-api-token" : "token",
+		"users-list" : [1],
+		"path" : { "default" : "` + t.TempDir() + `" }
+	}`
+}
+
+func TestParse(t *testing.T) {
+	s, err := common.Parse([]byte(valid(t)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Jackett.APIKey != "key" || s.Torrserver.Port != 8090 || s.Clients()[0].Host != "nas" {
+		t.Errorf("unexpected settings %+v", s)
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	file := path.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o600)
+	_, err := common.Parse([]byte(`{
+		"jackett" : { "host" : "nas", "port" : 70000, "api_key" : "key" },
+		"download-clients" : [{ "name" : "nas", "host" : "nas" }, { "name" : "nas", "type" : "deluge" }],
+		"categories" : [{ "name" : "movie", "path" : "/nonexistent" }, { "name" : "series", "path" : "`+file+`" }],
+		"users_list" : [1]
+	}`), nil)
+	report, ok := err.(common.SettingsErrors)
+	if !ok {
+		t.Fatalf("expected aggregated report, got %v", err)
+	}
+	for _, expected := range []string{
+		"unknown key jackett.api_key",
+		"unknown key users_list",
+		"telegram-api-token is required",
+		"jackett.api-key is required",
+		"jackett.port 70000 is out of range",
+		"download-clients[1].type deluge is unknown",
+		"download-clients[1].name nas is not unique",
+		"download-clients[1]: host or url is required",
+		"categories[0].path: stat /nonexistent: no such file or directory",
+		"categories[1].path: " + file + " is not a directory",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q is missing in the report", expected)
+		}
+	}
+	if len(report) != 10 {
+		t.Errorf("unexpected report %v", err)
+	}
+}
+
+func TestRolesReport(t *testing.T) {
+	settings := strings.Replace(valid(t), `"users-list" : [1],`, `"users-list" : [1],
+		"roles" : { "uploader" : { "commands" : ["search", "downloads"], "actions" : ["find:*"] } },
+		"user-roles" : { "2" : "uploader", "3" : "downloder", "4" : "viewer" },`, 1)
+	_, err := common.Parse([]byte(settings), nil)
+	report, ok := err.(common.SettingsErrors)
+	if !ok {
+		t.Fatalf("expected aggregated report, got %v", err)
+	}
+	for _, expected := range []string{
+		"roles.uploader.commands: downloads is unknown",
+		"user-roles.3: role downloder is unknown",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q is missing in the report", expected)
+		}
+	}
+	if len(report) != 2 {
+		t.Errorf("unexpected report %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	before := common.Settings
+	t.Cleanup(func() { common.Settings = before })
+	file := path.Join(t.TempDir(), common.DEFAULT_FILE)
+	os.WriteFile(file, []byte(`{ "telegram-api-token" : "" }`), 0o600)
+	common.Settings.TelegramAPIToken = "kept"
+	if err := common.Load(file); err == nil || common.Settings.TelegramAPIToken != "kept" {
+		t.Errorf("invalid settings must not be applied, got %v", err)
+	}
+	os.WriteFile(file, []byte(valid(t)), 0o600)
+	if err := common.Load(file); err != nil || common.Settings.TelegramAPIToken != "token" {
+		t.Errorf("valid settings must be applied, got %v", err)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	secret := path.Join(t.TempDir(), "token")
+	os.WriteFile(secret, []byte("from-file\n"), 0o600)
+	s, err := common.Parse([]byte(valid(t)), []string{
+		"TORRENTINO_TELEGRAM_API_TOKEN_FILE=" + secret,
+		"TORRENTINO_JACKETT_API_KEY=from-env",
+		"TORRENTINO_JACKETT_PORT=9118",
+		"TORRENTINO_JACKETT_INDEXERS=rutor, kinozal",
+		"TORRENTINO_USERS_LIST=[1, 2]",
+		"TORRENTINO_TORRSERVER_HOST=ts",
+		"TORRENTINO_USER_ROLES={\"3\" : \"viewer\"}",
+		"HOME=/root",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TelegramAPIToken != "from-file" || s.Jackett.APIKey != "from-env" || s.Jackett.Port != 9118 ||
+		len(s.Jackett.Indexers) != 2 || s.Jackett.Indexers[1] != "kinozal" || len(s.UsersList) != 2 ||
+		s.Torrserver.Host != "ts" || s.UserRoles[3] != "viewer" {
+		t.Errorf("unexpected settings %+v", s)
+	}
+
+	_, err = common.Parse([]byte(valid(t)), []string{"TORRENTINO_JACKETT_PORT=high", "TORRENTINO_JACKET_HOST=typo", "TORRENTINO_JACKETT_API_KEY_FILE=/nonexistent"})
+	for _, expected := range []string{"TORRENTINO_JACKETT_PORT: \"high\" is not int", "unknown environment variable TORRENTINO_JACKET_HOST",
+		"TORRENTINO_JACKETT_API_KEY_FILE: open /nonexistent"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q is missing in %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package backends starts all the fake services at once and builds the api clients pointed to them
+package backends
+
+import (
+	"testing"
+	"time"
+
+	"torrentino/api/client"
+	apijackett "torrentino/api/jackett"
+	"torrentino/api/resilience"
+	apitorrserver "torrentino/api/torrserver"
+	apitransmission "torrentino/api/transmission"
+	"torrentino/common"
+	"torrentino/fakes/jackett"
+	"torrentino/fakes/torrserver"
+	"torrentino/fakes/transmission"
+)
+
+const API_KEY = "test-api-key"
+
+type Backends struct {
+	Jackett      *jackett.Server
+	Transmission *transmission.Server
+	Torrserver   *torrserver.Server
+
+	JackettClient    *apijackett.Client // the api clients pointed to the fakes above
+	TorrserverClient *apitorrserver.Client
+	Clients          *client.Clients // the download clients, "transmission" first
+}
+
+// FastRetries shortens the backoff of the clients built during the test, the circuit opens
+// after the retries of one call as usual
+func FastRetries(t testing.TB) {
+	policy := resilience.DefaultPolicy
+	t.Cleanup(func() { resilience.DefaultPolicy = policy })
+	resilience.DefaultPolicy.BaseDelay = time.Millisecond
+	resilience.DefaultPolicy.MaxDelay = 5 * time.Millisecond
+}
+
+// Start runs the fakes for the test duration, download paths are set to empty temp dirs
+func Start(t testing.TB) *Backends {
+	FastRetries(t)
+	b := &Backends{
+		Jackett:      jackett.NewServer(API_KEY),
+		Transmission: transmission.NewServer(),
+		Torrserver:   torrserver.NewServer(),
+	}
+	t.Cleanup(b.Jackett.Close)
+	t.Cleanup(b.Transmission.Close)
+	t.Cleanup(b.Torrserver.Close)
+
+	s := &common.Settings
+	s.Jackett.Host, s.Jackett.Port = b.Jackett.HostPort()
+	s.Jackett.APIKey = API_KEY
+	s.Transmission.Host, s.Transmission.Port = b.Transmission.HostPort()
+	s.Torrserver.Host, s.Torrserver.Port = b.Torrserver.HostPort()
+	s.Path.Default = t.TempDir()
+	s.Path.Movie = t.TempDir()
+	s.Path.Series = t.TempDir()
+	s.CategoryList = nil // made of the paths above
+
+	var err error
+	if b.JackettClient, err = apijackett.NewClient(s.Jackett); err != nil {
+		t.Fatal(err)
+	}
+	b.TorrserverClient = apitorrserver.NewClient(s.Torrserver)
+	b.Clients = client.NewClients()
+	b.register(t, "transmission", b.Transmission)
+	return b
+}
+
+// AddTransmission runs one more Transmission instance added to Clients under the name
+func (b *Backends) AddTransmission(t testing.TB, name string) *transmission.Server {
+	s := transmission.NewServer()
+	t.Cleanup(s.Close)
+	b.register(t, name, s)
+	return s
+}
+
+func (b *Backends) register(t testing.TB, name string, s *transmission.Server) {
+	c, err := apitransmission.New(s.URL+transmission.RPC_PATH, s.Username, s.Password, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Clients.Set(name, c)
+}
@@ -0,0 +1,15 @@
+// Package fakes holds local stand-ins for the services the bot talks to, see subpackages
+package fakes
+
+import (
+	"net"
+	"net/http/httptest"
+	"strconv"
+)
+
+// HostPort splits the address of the test server, as settings keep them apart
+func HostPort(s *httptest.Server) (string, int) {
+	host, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
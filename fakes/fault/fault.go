@@ -0,0 +1,76 @@
+// Package fault injects failures into the fake backends
+package fault
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Fault struct {
+	Status int           // reply with this http status instead of the real answer, 0 - pass through
+	Delay  time.Duration // hold the request before answering, e.g. to trigger client timeout
+	Times  int           // how many requests are affected, 0 - all of them until Clear()
+}
+
+type Injector struct {
+	mu     sync.Mutex
+	faults []Fault
+	hits   int // requests served, faulty or not
+}
+
+// Inject queues the fault, faults are applied in order of injection
+func (in *Injector) Inject(f Fault) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.faults = append(in.faults, f)
+}
+
+func (in *Injector) Clear() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.faults = nil
+}
+
+// Hits returns the number of requests served
+func (in *Injector) Hits() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.hits
+}
+
+func (in *Injector) next() (f Fault, ok bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.hits++
+	if len(in.faults) == 0 {
+		return f, false
+	}
+	f = in.faults[0]
+	if f.Times > 0 {
+		if in.faults[0].Times--; in.faults[0].Times == 0 {
+			in.faults = in.faults[1:]
+		}
+	}
+	return f, true
+}
+
+// Wrap applies the pending fault before passing the request to the handler
+func (in *Injector) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, ok := in.next(); ok {
+			if f.Delay > 0 {
+				select {
+				case <-time.After(f.Delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if f.Status != 0 {
+				http.Error(w, http.StatusText(f.Status), f.Status)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+// Package jackett is a local stand-in for Jackett API with scripted results
+package jackett
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"torrentino/api/jackett"
+	"torrentino/fakes"
+	"torrentino/fakes/fault"
+)
+
+type Server struct {
+	*httptest.Server
+	fault.Injector
+	APIKey string
+
+	mu       sync.Mutex
+	results  []jackett.Result
+	indexers []jackett.Indexer
+	files    map[string][]byte
+	queries  []url.Values
+}
+
+func NewServer(apiKey string) *Server {
+	s := &Server{APIKey: apiKey, files: make(map[string][]byte)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2.0/indexers/{filter}/results", s.serveResults)
+	mux.HandleFunc("GET /api/v2.0/indexers", s.serveIndexers)
+	mux.HandleFunc("GET /files/{name}", s.serveFile)
+	s.Server = httptest.NewServer(s.Wrap(mux))
+	return s
+}
+
+func (s *Server) HostPort() (string, int) {
+	return fakes.HostPort(s.Server)
+}
+
+func (s *Server) AddResults(results ...jackett.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, results...)
+}
+
+func (s *Server) AddIndexers(indexers ...jackett.Indexer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexers = append(s.indexers, indexers...)
+}
+
+// AddFile serves the data (e.g. a .torrent) and returns its url, to be used as Result.Link
+func (s *Server) AddFile(name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = data
+	return s.URL + "/files/" + url.PathEscape(name)
+}
+
+// Queries returns parameters of the search requests received so far
+func (s *Server) Queries() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.queries)
+}
+
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Query().Get("apikey") != s.APIKey {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *Server) serveResults(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	params := r.URL.Query()
+	words := strings.Fields(strings.ToLower(params.Get("Query")))
+	trackers := params["Tracker[]"]
+
+	s.mu.Lock()
+	s.queries = append(s.queries, params)
+	response := jackett.QueryResults{Results: []jackett.Result{}, Indexers: s.indexers}
+	for _, result := range s.results {
+		title := strings.ToLower(result.Title)
+		if len(trackers) > 0 && !slices.Contains(trackers, result.TrackerId) {
+			continue
+		}
+		if !slices.ContainsFunc(words, func(word string) bool { return !strings.Contains(title, word) }) {
+			response.Results = append(response.Results, result)
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) serveIndexers(w http.ResponseWriter, r *http.Request) {
+	configured := r.URL.Query().Get("Configured") == "true"
+	s.mu.Lock()
+	indexers := []jackett.Indexer{}
+	for _, indexer := range s.indexers {
+		if !configured || indexer.Configured {
+			indexers = append(indexers, indexer)
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(indexers)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.files[r.PathValue("name")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Write(data)
+}
@@ -0,0 +1,264 @@
+// Package qbittorrent is a local stand-in for qBittorrent WebUI API v2 with scripted torrents,
+// the SID cookie is required and can be invalidated to make clients log in again
+package qbittorrent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"torrentino/api/client"
+	"torrentino/fakes"
+	"torrentino/fakes/fault"
+	"torrentino/fakes/transmission"
+)
+
+const (
+	USERNAME = "admin"
+	PASSWORD = "adminadmin"
+)
+
+type File struct {
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	Progress float64 `json:"progress"`
+	Priority int     `json:"priority"`
+}
+
+// Torrent in the wire format of torrents/info
+type Torrent struct {
+	Hash         string  `json:"hash"`
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Progress     float64 `json:"progress"`
+	Downloaded   int64   `json:"downloaded"`
+	Uploaded     int64   `json:"uploaded"`
+	Ratio        float64 `json:"ratio"`
+	TotalSize    int64   `json:"total_size"`
+	NumSeeds     int64   `json:"num_seeds"`
+	NumLeechs    int64   `json:"num_leechs"`
+	AddedOn      int64   `json:"added_on"`
+	CompletionOn int64   `json:"completion_on"`
+	SavePath     string  `json:"save_path"`
+	Category     string  `json:"category"`
+	Files        []File  `json:"-"`
+}
+
+type Server struct {
+	*httptest.Server
+	fault.Injector
+	V5 bool // pause/resume are renamed to stop/start since WebUI API v5
+
+	mu       sync.Mutex
+	sid      string
+	logins   int
+	torrents []*Torrent
+}
+
+func NewServer() *Server {
+	s := &Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v2/auth/login", s.serveLogin)
+	mux.HandleFunc("GET /api/v2/torrents/info", s.authorized(s.serveInfo))
+	mux.HandleFunc("GET /api/v2/torrents/files", s.authorized(s.serveFiles))
+	mux.HandleFunc("POST /api/v2/torrents/add", s.authorized(s.serveAdd))
+	mux.HandleFunc("POST /api/v2/torrents/delete", s.authorized(s.serveDelete))
+	mux.HandleFunc("POST /api/v2/torrents/filePrio", s.authorized(s.serveFilePrio))
+	mux.HandleFunc("POST /api/v2/torrents/setLocation", s.authorized(s.serveSetLocation))
+	mux.HandleFunc("POST /api/v2/torrents/{action}", s.authorized(s.serveState))
+	s.Server = httptest.NewServer(s.Wrap(mux))
+	return s
+}
+
+func (s *Server) HostPort() (string, int) {
+	return fakes.HostPort(s.Server)
+}
+
+// Logout invalidates the session, the next request gets 403 Forbidden
+func (s *Server) Logout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sid = ""
+}
+
+// Logins returns the number of successful logins so far
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// AddTorrent puts the torrent into the state, hash and state are generated when empty
+func (s *Server) AddTorrent(t Torrent) Torrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.add(t)
+}
+
+func (s *Server) add(t Torrent) *Torrent {
+	if t.Hash == "" {
+		t.Hash = transmission.Hash(t.Name)
+	}
+	if t.State == "" {
+		t.State = "downloading"
+	}
+	if t.AddedOn == 0 {
+		t.AddedOn = time.Now().Unix()
+	}
+	s.torrents = append(s.torrents, &t)
+	return &t
+}
+
+// Torrents returns a snapshot of the state
+func (s *Server) Torrents() (result []Torrent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.torrents {
+		result = append(result, *t)
+	}
+	return result
+}
+
+// ----------------------------------------
+func (s *Server) serveLogin(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.FormValue("username") != USERNAME || r.FormValue("password") != PASSWORD {
+		w.Write([]byte("Fails."))
+		return
+	}
+	s.logins++
+	s.sid = "sid-" + strconv.Itoa(s.logins)
+	http.SetCookie(w, &http.Cookie{Name: "SID", Value: s.sid, Path: "/"})
+	w.Write([]byte("Ok."))
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("SID")
+		s.mu.Lock()
+		ok := err == nil && s.sid != "" && cookie.Value == s.sid
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) find(hash string) *Torrent {
+	for _, t := range s.torrents {
+		if t.Hash == hash {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append([]*Torrent{}, s.torrents...))
+}
+
+func (s *Server) serveFiles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.find(r.URL.Query().Get("hash"))
+	if t == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append([]File{}, t.Files...))
+}
+
+func (s *Server) serveAdd(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link := r.FormValue("urls")
+	if link == "" {
+		w.Write([]byte("Fails."))
+		return
+	}
+	name, hash := path.Base(link), client.MagnetHash(link)
+	if hash != "" {
+		name = strings.TrimPrefix(link, "magnet:")
+	}
+	if hash == "" {
+		hash = transmission.Hash(link)
+	}
+	if s.find(hash) == nil {
+		t := Torrent{Name: name, Hash: hash, SavePath: r.FormValue("savepath"), Category: r.FormValue("category")}
+		if r.FormValue("paused") == "true" || r.FormValue("stopped") == "true" {
+			t.State = "pausedDL"
+		}
+		s.add(t)
+	}
+	w.Write([]byte("Ok."))
+}
+
+func (s *Server) serveDelete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashes := strings.Split(r.FormValue("hashes"), "|")
+	s.torrents = slices.DeleteFunc(s.torrents, func(t *Torrent) bool { return slices.Contains(hashes, t.Hash) })
+}
+
+func (s *Server) serveSetLocation(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hash := range strings.Split(r.FormValue("hashes"), "|") {
+		if t := s.find(hash); t != nil {
+			t.SavePath = r.FormValue("location")
+		}
+	}
+}
+
+func (s *Server) serveFilePrio(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.find(r.FormValue("hash"))
+	priority, err := strconv.Atoi(r.FormValue("priority"))
+	if t == nil || err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	for _, id := range strings.Split(r.FormValue("id"), "|") {
+		if i, err := strconv.Atoi(id); err == nil && i >= 0 && i < len(t.Files) {
+			t.Files[i].Priority = priority
+		}
+	}
+}
+
+func (s *Server) serveState(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var state string
+	switch action := r.PathValue("action"); {
+	case action == "pause" && !s.V5, action == "stop" && s.V5:
+		state = "pausedDL"
+	case action == "resume" && !s.V5, action == "start" && s.V5:
+		state = "downloading"
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	for _, hash := range strings.Split(r.FormValue("hashes"), "|") {
+		if t := s.find(hash); t != nil {
+			if state == "downloading" && t.Progress >= 1 {
+				t.State = "uploading"
+			} else {
+				t.State = state
+			}
+		}
+	}
+}
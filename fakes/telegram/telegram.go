@@ -0,0 +1,380 @@
+// Package telegram is a local stand-in for the subset of Telegram Bot API used by the bot,
+// it keeps the chats state so the tests can inspect rendered texts and keyboards
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const TOKEN = "test-token"
+
+type Message struct {
+	ID       int
+	ChatID   int64
+	Text     string
+	Keyboard [][]models.InlineKeyboardButton
+	Document string // file name of sent document
+	Data     []byte // content of sent document
+}
+
+// Button returns callback data of the button with given caption
+func (m *Message) Button(caption string) (string, bool) {
+	for _, row := range m.Keyboard {
+		for _, button := range row {
+			if button.Text == caption {
+				return button.CallbackData, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Buttons returns captions of all buttons, row by row
+func (m *Message) Buttons() (result []string) {
+	for _, row := range m.Keyboard {
+		for _, button := range row {
+			result = append(result, button.Text)
+		}
+	}
+	return result
+}
+
+type CallbackAnswer struct {
+	ID        string
+	Text      string
+	ShowAlert bool
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	messageID int
+	updateID  int64
+	chats     map[int64][]*Message
+	answers   []CallbackAnswer
+	commands  []models.BotCommand
+	pending   []*models.Update // waiting for getUpdates
+	calls     []string         // called methods, in order
+}
+
+func NewServer() *Server {
+	s := &Server{chats: make(map[int64][]*Message)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// NewBot creates the bot talking to this server, options are appended to the defaults
+func (s *Server) NewBot(options ...bot.Option) (*bot.Bot, error) {
+	return bot.New(TOKEN, append([]bot.Option{
+		bot.WithServerURL(s.URL),
+		bot.WithSkipGetMe(),
+	}, options...)...)
+}
+
+// ----------------------------------------
+type apiError struct {
+	code        int
+	description string
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+TOKEN+"/")
+	if method == r.URL.Path {
+		writeResponse(w, nil, &apiError{401, "Unauthorized"})
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeResponse(w, nil, &apiError{400, err.Error()})
+		return
+	}
+	if method == "getUpdates" {
+		writeResponse(w, s.getUpdates(r.Context()), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, method)
+
+	var result any
+	var err *apiError
+	switch method {
+	case "sendMessage":
+		result, err = s.sendMessage(r, "")
+	case "sendDocument":
+		result, err = s.sendMessage(r, "caption")
+	case "editMessageText":
+		result, err = s.editMessage(r, true)
+	case "editMessageReplyMarkup":
+		result, err = s.editMessage(r, false)
+	case "answerCallbackQuery":
+		s.answers = append(s.answers, CallbackAnswer{
+			ID:        r.FormValue("callback_query_id"),
+			Text:      r.FormValue("text"),
+			ShowAlert: r.FormValue("show_alert") == "true",
+		})
+		result = true
+	case "answerInlineQuery":
+		result = true
+	case "setMyCommands":
+		s.commands = nil
+		if e := json.Unmarshal([]byte(r.FormValue("commands")), &s.commands); e != nil {
+			err = &apiError{400, "can't parse commands"}
+		}
+		result = true
+	default:
+		err = &apiError{404, "Not Found"}
+	}
+	writeResponse(w, result, err)
+}
+
+func writeResponse(w http.ResponseWriter, result any, err *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": err.code, "description": err.description})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func parseKeyboard(r *http.Request) [][]models.InlineKeyboardButton {
+	var markup models.InlineKeyboardMarkup
+	if data := r.FormValue("reply_markup"); data != "" {
+		json.Unmarshal([]byte(data), &markup)
+	}
+	return markup.InlineKeyboard
+}
+
+func toModel(m *Message) *models.Message {
+	return &models.Message{ID: m.ID, Chat: models.Chat{ID: m.ChatID}, Date: int(time.Now().Unix()), Text: m.Text}
+}
+
+func (s *Server) sendMessage(r *http.Request, textField string) (any, *apiError) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		return nil, &apiError{400, "Bad Request: chat not found"}
+	}
+	s.messageID++
+	m := &Message{ID: s.messageID, ChatID: chatID, Keyboard: parseKeyboard(r)}
+	if textField == "" {
+		if m.Text = r.FormValue("text"); m.Text == "" {
+			return nil, &apiError{400, "Bad Request: message text is empty"}
+		}
+	} else {
+		m.Text = r.FormValue(textField)
+		if file, header, err := r.FormFile("document"); err == nil {
+			m.Document = header.Filename
+			m.Data, _ = io.ReadAll(file)
+			file.Close()
+		}
+	}
+	s.chats[chatID] = append(s.chats[chatID], m)
+	return toModel(m), nil
+}
+
+func (s *Server) editMessage(r *http.Request, withText bool) (any, *apiError) {
+	chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	messageID, _ := strconv.Atoi(r.FormValue("message_id"))
+	m := s.find(chatID, messageID)
+	if m == nil {
+		return nil, &apiError{400, "Bad Request: message to edit not found"}
+	}
+	text := m.Text
+	if withText {
+		text = r.FormValue("text")
+	}
+	keyboard := parseKeyboard(r)
+	if text == m.Text && reflect.DeepEqual(keyboard, m.Keyboard) {
+		return nil, &apiError{400, "Bad Request: message is not modified"}
+	}
+	m.Text = text
+	m.Keyboard = keyboard
+	return toModel(m), nil
+}
+
+func (s *Server) find(chatID int64, messageID int) *Message {
+	for _, m := range s.chats[chatID] {
+		if m.ID == messageID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *Server) getUpdates(ctx context.Context) []*models.Update {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		if len(s.pending) > 0 {
+			updates := s.pending
+			s.pending = nil
+			s.mu.Unlock()
+			return updates
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return []*models.Update{}
+		case <-ticker.C:
+		}
+	}
+}
+
+// ----------------------------------------
+// Inject queues the update to be delivered by getUpdates, for the bot started with bot.Start()
+func (s *Server) Inject(update *models.Update) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, update)
+}
+
+// TextUpdate makes a private message update from the user
+func (s *Server) TextUpdate(chatID int64, userID int64, text string) *models.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateID++
+	s.messageID++
+	return &models.Update{
+		ID: s.updateID,
+		Message: &models.Message{
+			ID:   s.messageID,
+			Chat: models.Chat{ID: chatID, Type: models.ChatTypePrivate},
+			From: &models.User{ID: userID},
+			Date: int(time.Now().Unix()),
+			Text: text,
+		},
+	}
+}
+
+// CallbackUpdate makes an update of the button pressed by the user
+func (s *Server) CallbackUpdate(chatID int64, messageID int, userID int64, data string) *models.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateID++
+	update := &models.Update{
+		ID: s.updateID,
+		CallbackQuery: &models.CallbackQuery{
+			ID:   strconv.FormatInt(s.updateID, 10),
+			From: models.User{ID: userID},
+			Data: data,
+		},
+	}
+	if m := s.find(chatID, messageID); m != nil {
+		update.CallbackQuery.Message = models.MaybeInaccessibleMessage{
+			Type:    models.MaybeInaccessibleMessageTypeMessage,
+			Message: toModel(m),
+		}
+	} else {
+		update.CallbackQuery.Message = models.MaybeInaccessibleMessage{
+			Type:                models.MaybeInaccessibleMessageTypeInaccessibleMessage,
+			InaccessibleMessage: &models.InaccessibleMessage{Chat: models.Chat{ID: chatID}, MessageID: messageID},
+		}
+	}
+	return update
+}
+
+// Messages returns a snapshot of messages sent to the chat
+func (s *Server) Messages(chatID int64) (result []Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.chats[chatID] {
+		result = append(result, *m)
+	}
+	return result
+}
+
+// LastMessage returns a snapshot of the last message sent to the chat
+func (s *Server) LastMessage(chatID int64) (Message, bool) {
+	messages := s.Messages(chatID)
+	if len(messages) == 0 {
+		return Message{}, false
+	}
+	return messages[len(messages)-1], true
+}
+
+func (s *Server) Answers() []CallbackAnswer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CallbackAnswer(nil), s.answers...)
+}
+
+func (s *Server) Commands() []models.BotCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.BotCommand(nil), s.commands...)
+}
+
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// ----------------------------------------
+// Harness drives the bot synchronously: every Send/Press returns after the handler is done
+type Harness struct {
+	*Server
+	T      testing.TB
+	Bot    *bot.Bot
+	Ctx    context.Context
+	ChatID int64
+	UserID int64
+}
+
+func NewHarness(t testing.TB, chatID int64, userID int64, options ...bot.Option) *Harness {
+	s := NewServer()
+	t.Cleanup(s.Close)
+	b, err := s.NewBot(append(options, bot.WithNotAsyncHandlers())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Harness{Server: s, T: t, Bot: b, Ctx: ctx, ChatID: chatID, UserID: userID}
+}
+
+// Send processes the text message from the harness user
+func (h *Harness) Send(text string) {
+	h.Bot.ProcessUpdate(h.Ctx, h.TextUpdate(h.ChatID, h.UserID, text))
+}
+
+// Press processes the press on the button with given caption of the message
+func (h *Harness) Press(m Message, caption string) {
+	h.T.Helper()
+	data, ok := m.Button(caption)
+	if !ok {
+		h.T.Fatalf("no button %q in %v", caption, m.Buttons())
+	}
+	h.PressData(m.ID, data)
+}
+
+// PressData processes the press on the button with given callback data
+func (h *Harness) PressData(messageID int, data string) {
+	h.Bot.ProcessUpdate(h.Ctx, h.CallbackUpdate(h.ChatID, messageID, h.UserID, data))
+}
+
+// Last returns the last message in the harness chat, fails the test if there are none
+func (h *Harness) Last() Message {
+	h.T.Helper()
+	m, ok := h.LastMessage(h.ChatID)
+	if !ok {
+		h.T.Fatal("no messages in chat")
+	}
+	return m
+}
@@ -0,0 +1,73 @@
+// Package torrentfile builds .torrent files for the fakes and the tests
+package torrentfile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	"github.com/zeebo/bencode"
+)
+
+type File struct {
+	Path   string // slash separated, relative to the torrent name
+	Length int64
+}
+
+type Torrent struct {
+	Name        string
+	Length      int64  // single file torrent, when there are no Files
+	Files       []File // multi file torrent
+	PieceLength int64  // 16 KiB by default
+	Private     bool
+	Trackers    []string
+}
+
+func (t *Torrent) info() map[string]any {
+	info := map[string]any{
+		"name":         t.Name,
+		"piece length": t.PieceLength,
+		"pieces":       "",
+	}
+	if t.PieceLength == 0 {
+		info["piece length"] = int64(16 << 10)
+	}
+	if t.Private {
+		info["private"] = 1
+	}
+	if len(t.Files) == 0 {
+		info["length"] = t.Length
+	} else {
+		files := make([]map[string]any, len(t.Files))
+		for i, f := range t.Files {
+			files[i] = map[string]any{"length": f.Length, "path": strings.Split(f.Path, "/")}
+		}
+		info["files"] = files
+	}
+	return info
+}
+
+// Encode makes bencoded .torrent content
+func (t *Torrent) Encode() []byte {
+	meta := map[string]any{"info": t.info()}
+	if len(t.Trackers) > 0 {
+		meta["announce"] = t.Trackers[0]
+		list := make([][]string, len(t.Trackers))
+		for i, tracker := range t.Trackers {
+			list[i] = []string{tracker}
+		}
+		meta["announce-list"] = list
+	}
+	data, err := bencode.EncodeBytes(meta)
+	if err != nil {
+		panic(err) // only plain types inside
+	}
+	return data
+}
+
+// InfoHash is sha1 of the bencoded info dictionary, the same as real clients compute
+func (t *Torrent) InfoHash() string {
+	data, _ := bencode.EncodeBytes(t.info())
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+// Package torrserver is a local stand-in for TorrServer "/torrents" API with scripted state
+package torrserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+
+	"torrentino/api/client"
+	"torrentino/fakes"
+	"torrentino/fakes/fault"
+	"torrentino/fakes/transmission"
+)
+
+const VERSION = "MatriX.fake"
+
+type File struct {
+	Id     int    `json:"id"`
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// Torrent in the wire format of "list" and "get" actions
+type Torrent struct {
+	Title       string `json:"title"`
+	Name        string `json:"name,omitempty"`
+	Hash        string `json:"hash"`
+	Poster      string `json:"poster"`
+	Data        string `json:"data"` // json, see api/torrserver TSListItem.DataStruct
+	TorrentSize int64  `json:"torrent_size"`
+	Stat        int    `json:"stat"`
+	FileStats   []File `json:"file_stats,omitempty"`
+	SaveToDB    bool   `json:"-"`
+}
+
+type Server struct {
+	*httptest.Server
+	fault.Injector
+
+	mu       sync.Mutex
+	torrents []Torrent
+	metadata map[string]Torrent
+}
+
+func NewServer() *Server {
+	s := &Server{metadata: make(map[string]Torrent)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /torrents", s.serveTorrents)
+	mux.HandleFunc("GET /echo", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(VERSION))
+	})
+	s.Server = httptest.NewServer(s.Wrap(mux))
+	return s
+}
+
+func (s *Server) HostPort() (string, int) {
+	return fakes.HostPort(s.Server)
+}
+
+// AddTorrent puts the torrent into the state, hash and data are generated when empty
+func (s *Server) AddTorrent(t Torrent) Torrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.Hash == "" {
+		t.Hash = transmission.Hash(t.Title)
+	}
+	if t.Data == "" {
+		t.Data = "{}"
+	}
+	s.torrents = append(s.torrents, t)
+	return t
+}
+
+// Metadata makes the files known to the torrents added by the hash afterwards,
+// as if they were fetched from the peers
+func (s *Server) Metadata(hash string, name string, files ...File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := Torrent{Name: name, FileStats: files}
+	for i := range files {
+		t.FileStats[i].Id = i + 1
+		t.TorrentSize += files[i].Length
+	}
+	s.metadata[hash] = t
+}
+
+// Torrents returns a snapshot of the state
+func (s *Server) Torrents() []Torrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.torrents)
+}
+
+func (s *Server) serveTorrents(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Action string `json:"action"`
+		Link   string `json:"link"`
+		Hash   string `json:"hash"`
+		Title  string `json:"title"`
+		Poster string `json:"poster"`
+		Save   bool   `json:"save_to_db"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.Action {
+	case "list":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(append([]Torrent{}, s.torrents...))
+	case "add":
+		if req.Link == "" {
+			http.Error(w, "link is empty", http.StatusBadRequest)
+			return
+		}
+		hash := client.MagnetHash(req.Link)
+		if hash == "" {
+			hash = transmission.Hash(req.Link)
+		}
+		t := Torrent{Title: req.Title, Hash: hash, Poster: req.Poster, Data: "{}", SaveToDB: req.Save}
+		if meta, ok := s.metadata[hash]; ok {
+			t.Name, t.FileStats, t.TorrentSize = meta.Name, meta.FileStats, meta.TorrentSize
+		}
+		if i := slices.IndexFunc(s.torrents, func(e Torrent) bool { return e.Hash == hash }); i == -1 {
+			s.torrents = append(s.torrents, t)
+		} else { // the known torrent is replied as it is
+			t = s.torrents[i]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	case "get":
+		i := slices.IndexFunc(s.torrents, func(e Torrent) bool { return e.Hash == req.Hash })
+		if i == -1 {
+			http.Error(w, "torrent not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.torrents[i])
+	case "rem":
+		s.torrents = slices.DeleteFunc(s.torrents, func(t Torrent) bool { return t.Hash == req.Hash })
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,391 @@
+// Package transmission is a local stand-in for Transmission RPC with scripted torrents,
+// it implements the session-id handshake, so clients have to deal with 409 renewals
+package transmission
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gotorrentparser "github.com/j-muller/go-torrent-parser"
+
+	"torrentino/api/client"
+	"torrentino/fakes"
+	"torrentino/fakes/fault"
+)
+
+const (
+	RPC_PATH       = "/transmission/rpc"
+	SESSION_HEADER = "X-Transmission-Session-Id"
+	VERSION        = "4.0.0 (fake)"
+)
+
+// torrent statuses
+const (
+	STOPPED     = 0
+	DOWNLOADING = 4
+	SEEDING     = 6
+)
+
+type File struct {
+	Name           string `json:"name"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+}
+
+type FileStat struct {
+	BytesCompleted int64 `json:"bytesCompleted"`
+	Wanted         bool  `json:"wanted"`
+	Priority       int64 `json:"priority"`
+}
+
+// Torrent in the wire format of torrent-get
+type Torrent struct {
+	ID                 int64      `json:"id"`
+	Name               string     `json:"name"`
+	HashString         string     `json:"hashString"`
+	Status             int64      `json:"status"`
+	PercentDone        float64    `json:"percentDone"`
+	DownloadedEver     int64      `json:"downloadedEver"`
+	UploadedEver       int64      `json:"uploadedEver"`
+	UploadRatio        float64    `json:"uploadRatio"`
+	TotalSize          int64      `json:"totalSize"`
+	AddedDate          int64      `json:"addedDate"`
+	DoneDate           int64      `json:"doneDate"`
+	DownloadDir        string     `json:"downloadDir"`
+	PeersGettingFromUs int64      `json:"peersGettingFromUs"`
+	PeersSendingToUs   int64      `json:"peersSendingToUs"`
+	Labels             []string   `json:"labels"`
+	Group              string     `json:"group"`
+	Error              int64      `json:"error"`
+	ErrorString        string     `json:"errorString"`
+	Files              []File     `json:"files"`
+	FileStats          []FileStat `json:"fileStats"`
+}
+
+type Server struct {
+	*httptest.Server
+	fault.Injector
+	Username string // basic auth is required when set
+	Password string
+
+	mu        sync.Mutex
+	sessionID string
+	renewals  int
+	nextID    int64
+	torrents  []*Torrent
+	methods   []string
+	metadata  map[string]Torrent
+}
+
+func NewServer() *Server {
+	s := &Server{nextID: 1, metadata: make(map[string]Torrent)}
+	s.RenewSession()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+RPC_PATH, s.serveRPC)
+	s.Server = httptest.NewServer(s.Wrap(mux))
+	return s
+}
+
+func (s *Server) HostPort() (string, int) {
+	return fakes.HostPort(s.Server)
+}
+
+// RenewSession invalidates the session id, the next request gets 409 Conflict
+func (s *Server) RenewSession() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewals++
+	s.sessionID = "session-" + strconv.Itoa(s.renewals)
+}
+
+// AddTorrent puts the torrent into the state, ID and hash are generated when empty
+func (s *Server) AddTorrent(t Torrent) Torrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.add(t)
+}
+
+func (s *Server) add(t Torrent) *Torrent {
+	if t.ID == 0 {
+		t.ID = s.nextID
+	}
+	s.nextID = max(s.nextID, t.ID) + 1
+	if t.HashString == "" {
+		t.HashString = Hash(t.Name)
+	}
+	if t.AddedDate == 0 {
+		t.AddedDate = time.Now().Unix()
+	}
+	fileStats(&t)
+	s.torrents = append(s.torrents, &t)
+	return &t
+}
+
+// fileStats completes the stats of the files, all of them are wanted
+func fileStats(t *Torrent) {
+	for len(t.FileStats) < len(t.Files) {
+		t.FileStats = append(t.FileStats, FileStat{
+			BytesCompleted: t.Files[len(t.FileStats)].BytesCompleted,
+			Wanted:         true,
+		})
+	}
+}
+
+// Metadata makes the files known to the magnets added by the hash afterwards,
+// as if they were fetched from the peers once the torrent is started
+func (s *Server) Metadata(hash string, name string, files ...File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metadata[hash] = Torrent{Name: name, Files: files}
+}
+
+// Torrents returns a snapshot of the state
+func (s *Server) Torrents() (result []Torrent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.torrents {
+		result = append(result, *t)
+	}
+	return result
+}
+
+// Update changes the torrent in place, e.g. to script download progress
+func (s *Server) Update(id int64, fn func(t *Torrent)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.torrents {
+		if t.ID == id {
+			fn(t)
+			return true
+		}
+	}
+	return false
+}
+
+// Methods returns the rpc methods called so far, successful or not
+func (s *Server) Methods() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.methods)
+}
+
+// Hash makes stable fake info hash for a name, url or magnet without btih
+func Hash(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// ----------------------------------------
+type request struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       *int            `json:"tag"`
+}
+
+type response struct {
+	Result    string `json:"result"`
+	Arguments any    `json:"arguments"`
+	Tag       *int   `json:"tag"`
+}
+
+func (s *Server) serveRPC(w http.ResponseWriter, r *http.Request) {
+	if s.Username != "" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != s.Username || pass != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Transmission"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Header.Get(SESSION_HEADER) != s.sessionID {
+		w.Header().Set(SESSION_HEADER, s.sessionID)
+		http.Error(w, "Conflict", http.StatusConflict)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.methods = append(s.methods, req.Method)
+
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		Filename        string          `json:"filename"`
+		DownloadDir     string          `json:"download-dir"`
+		Paused          bool            `json:"paused"`
+		DeleteLocalData bool            `json:"delete-local-data"`
+		FilesWanted     []int           `json:"files-wanted"`
+		FilesUnwanted   []int           `json:"files-unwanted"`
+		PriorityHigh    []int           `json:"priority-high"`
+		PriorityNormal  []int           `json:"priority-normal"`
+		PriorityLow     []int           `json:"priority-low"`
+		Labels          []string        `json:"labels"`
+		Group           string          `json:"group"`
+		Location        string          `json:"location"`
+		Move            bool            `json:"move"`
+	}
+	if len(req.Arguments) > 0 {
+		json.Unmarshal(req.Arguments, &args)
+	}
+
+	var result any = struct{}{}
+	status := "success"
+	switch req.Method {
+	case "torrent-get":
+		torrents := []*Torrent{}
+		for _, t := range s.selected(args.IDs) {
+			torrents = append(torrents, t)
+		}
+		result = map[string]any{"torrents": torrents}
+	case "torrent-add":
+		result, status = s.torrentAdd(args.Filename, args.DownloadDir, args.Paused)
+	case "torrent-remove":
+		selected := s.selected(args.IDs)
+		s.torrents = slices.DeleteFunc(s.torrents, func(t *Torrent) bool { return slices.Contains(selected, t) })
+	case "torrent-start", "torrent-start-now":
+		for _, t := range s.selected(args.IDs) {
+			if meta, ok := s.metadata[t.HashString]; ok && len(t.Files) == 0 {
+				t.Name, t.Files = meta.Name, meta.Files
+				fileStats(t)
+			}
+			t.Status = DOWNLOADING
+			if t.PercentDone >= 1 {
+				t.Status = SEEDING
+			}
+		}
+	case "torrent-stop":
+		for _, t := range s.selected(args.IDs) {
+			t.Status = STOPPED
+		}
+	case "torrent-set":
+		for _, t := range s.selected(args.IDs) {
+			setFiles(t, args.FilesWanted, func(f *FileStat) { f.Wanted = true })
+			setFiles(t, args.FilesUnwanted, func(f *FileStat) { f.Wanted = false })
+			setFiles(t, args.PriorityHigh, func(f *FileStat) { f.Priority = 1 })
+			setFiles(t, args.PriorityNormal, func(f *FileStat) { f.Priority = 0 })
+			setFiles(t, args.PriorityLow, func(f *FileStat) { f.Priority = -1 })
+			if args.Labels != nil {
+				t.Labels = args.Labels
+			}
+			if args.Group != "" {
+				t.Group = args.Group
+			}
+		}
+	case "torrent-set-location":
+		for _, t := range s.selected(args.IDs) {
+			t.DownloadDir = args.Location
+		}
+	case "session-get":
+		result = map[string]any{"version": VERSION, "rpc-version": 17, "download-dir": "/downloads"}
+	case "session-stats":
+		active := 0
+		for _, t := range s.torrents {
+			if t.Status != STOPPED {
+				active++
+			}
+		}
+		result = map[string]any{"torrentCount": len(s.torrents), "activeTorrentCount": active, "pausedTorrentCount": len(s.torrents) - active}
+	default:
+		status = "method name not recognized"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{status, result, req.Tag})
+}
+
+// selected resolves "ids" argument: absent - all torrents, a number, a hash or a list of them
+func (s *Server) selected(raw json.RawMessage) (result []*Torrent) {
+	if len(raw) == 0 {
+		return s.torrents
+	}
+	var ids []any
+	if err := json.Unmarshal(raw, &ids); err != nil {
+		var id any
+		json.Unmarshal(raw, &id)
+		ids = []any{id}
+	}
+	for _, t := range s.torrents {
+		for _, id := range ids {
+			if n, ok := id.(float64); ok && int64(n) == t.ID || id == t.HashString {
+				result = append(result, t)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func setFiles(t *Torrent, indexes []int, fn func(f *FileStat)) {
+	for _, i := range indexes {
+		if i >= 0 && i < len(t.FileStats) {
+			fn(&t.FileStats[i])
+		}
+	}
+}
+
+func (s *Server) torrentAdd(filename string, downloadDir string, paused bool) (any, string) {
+	if filename == "" {
+		return nil, "no filename"
+	}
+	name, hash, files := path.Base(filename), "", []File(nil)
+	if u, err := url.Parse(filename); err == nil && u.Scheme == "magnet" {
+		name = u.Query().Get("dn")
+		hash = client.MagnetHash(filename)
+		if meta, ok := s.metadata[hash]; ok && !paused { // the paused magnet does not fetch its metadata
+			name, files = meta.Name, meta.Files
+		}
+	} else if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if torrent := fetch(filename); torrent != nil { // a real .torrent is added with its files
+			hash = torrent.InfoHash
+			for _, f := range torrent.Files {
+				files = append(files, File{Name: strings.Join(f.Path, "/"), Length: f.Length})
+			}
+			if len(files) > 0 {
+				name, _, _ = strings.Cut(files[0].Name, "/")
+			}
+		}
+	}
+	if hash == "" {
+		hash = Hash(filename)
+	}
+	for _, t := range s.torrents {
+		if t.HashString == hash {
+			return map[string]any{"torrent-duplicate": map[string]any{"id": t.ID, "name": t.Name, "hashString": t.HashString}}, "success"
+		}
+	}
+	status := int64(DOWNLOADING)
+	if paused {
+		status = STOPPED
+	}
+	t := s.add(Torrent{Name: name, HashString: hash, DownloadDir: downloadDir, Status: status, Files: files})
+	return map[string]any{"torrent-added": map[string]any{"id": t.ID, "name": t.Name, "hashString": t.HashString}}, "success"
+}
+
+// fetch downloads and parses a .torrent, nil if it is not one
+func fetch(link string) *gotorrentparser.Torrent {
+	res, err := http.Get(link)
+	if err != nil {
+		return nil
+	}
+	defer res.Body.Close()
+	torrent, err := gotorrentparser.Parse(res.Body)
+	if err != nil {
+		return nil
+	}
+	return torrent
+}
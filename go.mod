@@ -12,6 +12,7 @@ require (
 	github.com/hekmon/transmissionrpc/v2 v2.0.1
 	github.com/j-muller/go-torrent-parser v0.0.0-20211014072822-db02b4099054
 	github.com/pkg/errors v0.9.1
+	github.com/zeebo/bencode v1.0.0
 	golang.org/x/net v0.30.0
 )
 
@@ -21,6 +22,5 @@ require (
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hekmon/cunits/v2 v2.1.0 // indirect
 	github.com/stretchr/testify v1.9.0 // indirect
-	github.com/zeebo/bencode v1.0.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
 )
@@ -6,18 +6,21 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
 	"github.com/gensword/collections"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
-	"github.com/hekmon/transmissionrpc/v2"
+	"github.com/pkg/errors"
 
-	"torrentino/api/transmission"
+	"torrentino/api/client"
+	"torrentino/api/resilience"
 	"torrentino/common"
+	"torrentino/common/owners"
 	"torrentino/common/paginator"
 	"torrentino/common/utils"
 )
@@ -39,22 +42,52 @@ var ExtIcons map[string]string = map[string]string{
 }
 
 type ListItem struct {
-	transmissionrpc.Torrent
+	client.Torrent
+	Client   string // instance name, empty for the files unknown to any client
+	Owner    string // who added the torrent from search, empty if unknown
 	Ext      string
 	ExtCount int
 	IsDir    bool
-	Status   string
 }
 
+// id identifies the item across reloads: the torrent in its instance or the file on disk
+func (item *ListItem) id() string {
+	if item.Client != "" {
+		return item.Client + "@" + item.Hash
+	}
+	return path.Join(item.DownloadDir, item.Name)
+}
+
+const (
+	NO_CLIENT   = "disk"   // filter value for the files unknown to any client
+	NO_OWNER    = "nobody" // filter value for the torrents added outside of the bot
+	NO_CATEGORY = "other"  // filter value for the items outside of the configured categories
+)
+
 type ListPaginator struct {
 	paginator.Paginator
+	clients *client.Clients
+	moving  string // id of the item whose actions are the move targets, the items are new on every reload
 }
 
 // ----------------------------------------
-func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update) *ListPaginator {
+func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update, clients *client.Clients) *ListPaginator {
 	var p ListPaginator
 	p = ListPaginator{
 		*paginator.New(ctx, b, update, "list", 4, &p, &p, &p),
+		clients,
+		"",
+	}
+	p.SetupSorting([]paginator.Sorting{
+		{Attribute: "AddedDate", Alias: "date", Order: 1},
+		{Attribute: "Name", Alias: "name", Order: 1},
+		{Attribute: "DownloadedEver", Alias: "size", Order: 0},
+		{Attribute: "IsDir", Alias: "dir", Order: 0},
+	})
+	if clients.Len() > 1 {
+		p.SetupFiltering([]string{"Status", "Category", "Owner", "Client"})
+	} else {
+		p.SetupFiltering([]string{"Status", "Category", "Owner"})
 	}
 	return &p
 }
@@ -63,6 +96,11 @@ func (p *ListPaginator) Item(i int) *ListItem {
 	return p.Paginator.Item(i).(*ListItem)
 }
 
+// paginator.Identifier
+func (p *ListPaginator) ID(i int) string {
+	return p.Item(i).id()
+}
+
 // method overload
 func (p *ListPaginator) Line(i int) string {
 	result := ""
@@ -70,72 +108,80 @@ func (p *ListPaginator) Line(i int) string {
 	if item.IsDir {
 		result = "📁[" + strconv.Itoa(item.ExtCount) + "x | " + item.Ext + "]"
 	}
-	var peersGettingFromUs int64
-	var peersSendingToUs int64
-	var uploadRatio float64
-	if item.PeersSendingToUs == nil {
-		item.PeersSendingToUs = &peersSendingToUs
-	}
-
-	if item.PeersGettingFromUs == nil {
-		item.PeersConnected = &peersGettingFromUs
-	}
-
-	if item.UploadRatio == nil || *item.UploadRatio < 0 {
-		item.UploadRatio = &uploadRatio
-	}
-
 	result = result +
 		ExtIcons[item.Ext] +
-		"" + *item.Name +
-		" [" + utils.FormatFileSize(uint64(*item.DownloadedEver)) + "]" +
-		" [" + fmt.Sprintf("%.0f", *item.PercentDone*100) + "%]" +
-		" [" + fmt.Sprintf("%.2f", *item.UploadRatio) + "x]" +
+		"" + item.Name +
+		" [" + utils.FormatFileSize(uint64(item.DownloadedEver)) + "]" +
+		" [" + fmt.Sprintf("%.0f", item.PercentDone*100) + "%]" +
+		" [" + fmt.Sprintf("%.2f", item.UploadRatio) + "x]" +
 		(func() string {
 			switch item.Status {
-			case "seeding":
-				return " [" + item.Status + ":" + fmt.Sprintf("%dp", *item.PeersGettingFromUs) + "]"
-			case "downloading":
-				return " [" + item.Status + ":" + fmt.Sprintf("%dp", *item.PeersSendingToUs) + "]"
+			case client.STATUS_SEEDING:
+				return " [" + item.Status + ":" + fmt.Sprintf("%dp", item.PeersGettingFromUs) + "]"
+			case client.STATUS_DOWNLOADING:
+				return " [" + item.Status + ":" + fmt.Sprintf("%dp", item.PeersSendingToUs) + "]"
 			}
 			return " [" + item.Status + "]"
 		})()
 
+	if item.Client != "" && p.clients.Len() > 1 {
+		result += " @" + item.Client
+	}
+	if item.Owner != "" {
+		result += " 👤" + item.Owner
+	}
+
 	return result
 }
 
 // method overload
 func (p *ListPaginator) Footer() string {
 
-	fs := syscall.Statfs_t{}
-	err := syscall.Statfs(common.Settings.Path.Default, &fs)
+	diskUsed, diskFree, err := utils.DiskUsage(common.Current().Categories()[0].Path)
 	if err != nil {
 		return ""
 	}
-	diskAll := fs.Blocks * uint64(fs.Bsize)
-	diskFree := fs.Bfree * uint64(fs.Bsize)
-	diskUsed := diskAll - diskFree
 
 	var downloaded uint64
 	var uploaded uint64
 	for i := range p.Len() {
 		item := p.Item(i)
-		downloaded += uint64(*item.DownloadedEver)
-		uploaded += uint64(*item.UploadRatio * float64(*item.DownloadedEver))
+		downloaded += uint64(item.DownloadedEver)
+		uploaded += uint64(item.UploadedEver)
 	}
 
 	return utils.FormatFileSize(downloaded) + " downoad / " +
 		utils.FormatFileSize(uploaded) + " upload " + "\nvolume: " +
 		utils.FormatFileSize(diskUsed) + " used / " +
 		utils.FormatFileSize(diskFree) + " free"
-	// + utils.FormatFileSize(diskAll) + " total "
 }
 
 // method overload
 func (p *ListPaginator) Stringify(i int, attribute string) string {
 	item := p.Item(i)
-	if attribute == "Status" {
+	switch attribute {
+	case "Status":
 		return item.Status
+	case "Client":
+		if item.Client == "" {
+			return NO_CLIENT
+		}
+		return item.Client
+	case "Owner":
+		if item.Owner == "" {
+			return NO_OWNER
+		}
+		return item.Owner
+	case "Category":
+		if item.Category == "" {
+			return NO_CATEGORY
+		}
+		for _, category := range common.Current().Categories() {
+			if category.Name == item.Category {
+				return category.Icon + category.Name
+			}
+		}
+		return item.Category
 	}
 	return ""
 }
@@ -147,11 +193,11 @@ func (p *ListPaginator) Compare(i int, j int, attribute string) bool {
 
 	switch attribute {
 	case "AddedDate":
-		return (*a.AddedDate).Compare(*b.AddedDate) == -1
+		return a.AddedDate.Before(b.AddedDate)
 	case "Name":
-		return *a.Name < *b.Name
+		return a.Name < b.Name
 	case "DownloadedEver":
-		return *a.DownloadedEver < *b.DownloadedEver
+		return a.DownloadedEver < b.DownloadedEver
 	case "IsDir":
 		return b.IsDir && !a.IsDir
 	}
@@ -161,15 +207,27 @@ func (p *ListPaginator) Compare(i int, j int, attribute string) bool {
 // method overload
 func (p *ListPaginator) Actions(i int) (result []string) {
 	item := p.Item(i)
+	if item.id() == p.moving {
+		for _, target := range moveTargets(item) {
+			result = append(result, "move:"+target.Name)
+		}
+		return append(result, "cancel")
+	}
 
 	switch item.Status {
-	case "downloading", "seeding":
+	case client.STATUS_DOWNLOADING, client.STATUS_SEEDING:
 		result = append(result, "pause")
 	default:
-		if item.Status != "unknown" {
+		if item.Status != client.STATUS_UNKNOWN {
 			result = append(result, "start")
 		}
 	}
+	if item.Client != "" {
+		result = append(result, "files")
+	}
+	if len(moveTargets(item)) > 0 {
+		result = append(result, "move to…")
+	}
 	result = append(result, "delete")
 	return result
 }
@@ -178,49 +236,116 @@ func (p *ListPaginator) Actions(i int) (result []string) {
 func (p *ListPaginator) Execute(i int, action string) (unselect bool) {
 	var err error
 	item := p.Item(i)
+	if action != "move to…" {
+		p.moving = ""
+	}
 	switch action {
+	case "move to…", "cancel": // the targets replace the actions of the item until one is chosen
+		if action == "move to…" {
+			p.moving = item.id()
+		}
+		return false
 	case "delete":
-		if item.ID != nil {
-			err = transmission.Delete(*item.ID)
+		if item.Client != "" {
+			err = p.withClient(item, func(c client.DownloadClient) error { return c.Delete(p.Context(), item.Hash, true) })
+			if owner, ok := owners.Get(item.Hash); ok && err == nil && owner.Target == item.Client {
+				owners.Forget(item.Hash)
+			}
 		} else {
 			if item.IsDir {
-				err = os.RemoveAll(path.Join(*item.DownloadDir, *item.Name))
+				err = os.RemoveAll(path.Join(item.DownloadDir, item.Name))
 			} else {
-				err = os.Remove(path.Join(*item.DownloadDir, *item.Name))
+				err = os.Remove(path.Join(item.DownloadDir, item.Name))
 			}
 		}
 		if err == nil {
 			p.Delete(i)
 			p.Sort()
 		}
+	case "files": // the view outlives this list, so it gets its own session
+		files := NewFilesPaginator(context.WithoutCancel(p.Context()), p.Bot(), p.Reply(), p.clients, item.Client, item.Hash)
+		if err = files.Reload(); err == nil {
+			files.Show()
+		}
 	case "start":
-		err = transmission.Start(*item.ID)
+		err = p.withClient(item, func(c client.DownloadClient) error { return c.Start(p.Context(), item.Hash) })
 	case "pause":
-		err = transmission.Pause(*item.ID)
+		err = p.withClient(item, func(c client.DownloadClient) error { return c.Pause(p.Context(), item.Hash) })
+	default:
+		for _, target := range moveTargets(item) {
+			if action == "move:"+target.Name {
+				go p.move(*item, target, p.ChatID())
+			}
+		}
 	}
 
 	if err != nil {
 		utils.LogError(err)
+		p.ReplyMessage(resilience.Message(err))
 	}
 	return true
 }
 
+// categoryOf resolves the label or the download dir of the torrent to a configured category,
+// an unknown label (e.g. qBittorrent category) is kept as is
+func categoryOf(t *client.Torrent) string {
+	categories := common.Current().Categories()
+	for _, category := range categories {
+		if t.Category != "" && slices.Contains(category.Labels, t.Category) {
+			return category.Name
+		}
+	}
+	for _, category := range categories {
+		if category.Path != "" && filepath.Clean(category.Path) == filepath.Clean(t.DownloadDir) {
+			return category.Name
+		}
+	}
+	return t.Category
+}
+
+// run fn on the instance the item belongs to
+func (p *ListPaginator) withClient(item *ListItem, fn func(c client.DownloadClient) error) error {
+	c, ok := p.clients.Get(item.Client)
+	if !ok {
+		return errors.New("download client " + item.Client + " is not configured")
+	}
+	return errors.Wrap(fn(c), item.Client)
+}
+
+// list torrents of every instance, fails only if none of them responds
+func listAll(ctx context.Context, clients *client.Clients) (items []ListItem, err error) {
+	failed := 0
+	for name, c := range clients.Iter() {
+		torrents, e := c.List(ctx)
+		if e != nil {
+			utils.LogError(errors.Wrap(e, name))
+			if failed++; err == nil {
+				err = e
+			}
+			continue
+		}
+		for i := range torrents {
+			items = append(items, ListItem{Torrent: torrents[i], Client: name})
+		}
+	}
+	if failed < clients.Len() {
+		err = nil
+	}
+	return items, err
+}
+
 func (p *ListPaginator) Reload() error {
 
-	torrents, err := transmission.List()
+	listItems, err := listAll(p.Context(), p.clients)
 	if err != nil {
-		utils.LogError(err)
 		return err
 	}
 
-	listItems := make([]ListItem, len(*torrents), len(*torrents)*2)
 	torrentNames := make(map[string]bool)
-	for i := range *torrents {
-		listItems[i] = ListItem{(*torrents)[i], "", 0, false, ""}
-
+	for i := range listItems {
 		extCounter := collections.NewCounter()
-		for _, file := range (*torrents)[i].Files {
-			extCounter.Add(filepath.Ext((*file).Name))
+		for _, file := range listItems[i].Files {
+			extCounter.Add(filepath.Ext(file.Name))
 		}
 		if extCounter.Len() > 0 {
 			mostCommon := extCounter.MostCommon(1)[0]
@@ -228,11 +353,14 @@ func (p *ListPaginator) Reload() error {
 			listItems[i].ExtCount = mostCommon.Value
 		}
 		listItems[i].IsDir = listItems[i].ExtCount > 1
-		listItems[i].Status = (*torrents)[i].Status.String()
-		torrentNames[*listItems[i].Name] = true
+		if owner, ok := owners.Get(listItems[i].Hash); ok {
+			listItems[i].Owner = owner.Name()
+		}
+		listItems[i].Category = categoryOf(&listItems[i].Torrent)
+		torrentNames[listItems[i].Name] = true
 	}
 
-	readDir := func(targetDir string) {
+	readDir := func(targetDir string, category string) {
 		dir, err := utils.ReadDir(targetDir, false)
 		if err != nil {
 			utils.LogError(err)
@@ -242,9 +370,6 @@ func (p *ListPaginator) Reload() error {
 				if _, ok := torrentNames[dirEntry.Name]; !ok {
 					name := dirEntry.Name
 					size := int64(dirEntry.Size)
-					status := transmissionrpc.TorrentStatus(404)
-					modTime := dirEntry.ModTime
-					zero := float64(0.0)
 					ext := strings.ToLower(filepath.Ext(dirEntry.Name))
 					extCount := 0
 					extCounter := collections.NewCounter()
@@ -266,45 +391,60 @@ func (p *ListPaginator) Reload() error {
 
 					listItems = append(listItems,
 						ListItem{
-							transmissionrpc.Torrent{
-								Name:           &name,
-								DownloadedEver: &size,
-								Status:         &status,
-								PercentDone:    &zero,
-								UploadRatio:    &zero,
-								AddedDate:      &modTime,
-								DownloadDir:    &targetDir,
+							client.Torrent{
+								Name:           name,
+								DownloadedEver: size,
+								Status:         client.STATUS_UNKNOWN,
+								AddedDate:      dirEntry.ModTime,
+								DownloadDir:    targetDir,
+								Category:       category,
 							},
+							"",
+							"",
 							ext,
 							extCount,
 							dirEntry.IsDir,
-							"unknown",
 						})
 				}
 			}
 		}
 	}
-	readDir(common.Settings.Path.Default)
-	readDir(common.Settings.Path.Movie)
-	readDir(common.Settings.Path.Series)
-
-	p.Alloc(len(listItems))
-	for i := range listItems {
-		p.Append(&listItems[i])
+	scanned := make(map[string]bool)
+	for _, category := range common.Current().Categories() {
+		if dir := filepath.Clean(category.Path); category.Path != "" && !scanned[dir] {
+			scanned[dir] = true
+			readDir(category.Path, category.Name)
+		}
 	}
+
+	p.Locked(func() {
+		p.Alloc(len(listItems))
+		for i := range listItems {
+			p.Append(&listItems[i])
+		}
+	})
 	return nil
 }
 
 // -------------------------------------------------------------------------
-var Updater = func() func(ctx context.Context, p *ListPaginator) {
-	var cancel context.CancelFunc
-	return func(ctx context.Context, p *ListPaginator) {
-		if cancel != nil {
+var updateInterval = 5 * time.Second
+
+// Updater refreshes the list periodically until the session expires,
+// a new list in the same chat replaces the previous one
+var Updater = func() func(ctx context.Context, chatID int64, p *ListPaginator) {
+	var mu sync.Mutex
+	cancels := make(map[int64]context.CancelFunc)
+	return func(ctx context.Context, chatID int64, p *ListPaginator) {
+		mu.Lock()
+		if cancel, ok := cancels[chatID]; ok {
 			cancel()
 		}
-		var updaterCtx context.Context
-		updaterCtx, cancel = context.WithCancel(ctx)
-		ticker := time.NewTicker(5 * time.Second)
+		updaterCtx, cancel := context.WithCancel(ctx)
+		cancels[chatID] = cancel
+		mu.Unlock()
+		defer cancel()
+
+		ticker := time.NewTicker(updateInterval)
 		defer ticker.Stop()
 
 		for {
@@ -319,20 +459,27 @@ var Updater = func() func(ctx context.Context, p *ListPaginator) {
 	}
 }()
 
-func Handler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	p := NewPaginator(ctx, b, update)
-	p.SetupSorting([]paginator.Sorting{
-		{Attribute: "AddedDate", Alias: "date", Order: 1},
-		{Attribute: "Name", Alias: "name", Order: 1},
-		{Attribute: "DownloadedEver", Alias: "size", Order: 0},
-		{Attribute: "IsDir", Alias: "dir", Order: 0},
-	})
-	p.SetupFiltering([]string{"Status"})
+// NewRestorer rehydrates the list after restart, see paginator.RegisterRestorer
+func NewRestorer(clients *client.Clients) paginator.Restorer {
+	return func(ctx context.Context, b *bot.Bot, state *paginator.State) (*paginator.Paginator, error) {
+		p := NewPaginator(ctx, b, nil, clients)
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+		p.Restore(state)
+		go Updater(p.Context(), state.ChatID, p)
+		return &p.Paginator, nil
+	}
+}
 
-	if err := p.Reload(); err != nil {
-		p.ReplyMessage(err.Error())
-	} else {
-		p.Show()
-		go Updater(ctx, p)
+func NewHandler(clients *client.Clients) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		p := NewPaginator(ctx, b, update, clients)
+		if err := p.Reload(); err != nil {
+			p.ReplyMessage(resilience.Message(err))
+		} else {
+			p.Show()
+			go Updater(p.Context(), update.Message.Chat.ID, p)
+		}
 	}
 }
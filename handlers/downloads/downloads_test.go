@@ -0,0 +1,415 @@
+package downloads
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+
+	"torrentino/api/client"
+	apiqbittorrent "torrentino/api/qbittorrent"
+	apitransmission "torrentino/api/transmission"
+	"torrentino/common"
+	"torrentino/common/owners"
+	"torrentino/common/paginator"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+	"torrentino/fakes/qbittorrent"
+	"torrentino/fakes/telegram"
+	"torrentino/fakes/transmission"
+)
+
+func newHarness(t *testing.T, clients *client.Clients) *telegram.Harness {
+	common.Settings.UsersList = []int64{1}
+	return telegram.NewHarness(t, 1, 1, bot.WithMessageTextHandler("/downloads", bot.MatchTypeExact, NewHandler(clients)))
+}
+
+func TestTransmissionDown(t *testing.T) {
+	backends.FastRetries(t)
+	c, _ := apitransmission.New("http://127.0.0.1:1", "", "", nil) // nothing listens there
+	clients := client.NewClients()
+	clients.Set("transmission", c)
+	h := newHarness(t, clients)
+	h.Send("/downloads")
+	m := h.Last()
+	if m.Text != "Transmission unavailable, retrying in 30 s" {
+		t.Errorf("expected Transmission error, got %q", m.Text)
+	}
+	if len(m.Keyboard) != 0 {
+		t.Errorf("error reply must not have keyboard, got %v", m.Buttons())
+	}
+}
+
+func TestQBittorrentDown(t *testing.T) {
+	backends.FastRetries(t)
+	c, _ := apiqbittorrent.New("http://127.0.0.1:1", "", "", nil) // nothing listens there
+	clients := client.NewClients()
+	clients.Set("seedbox", c)
+	h := newHarness(t, clients)
+	h.Send("/downloads")
+	if m := h.Last(); m.Text != "qBittorrent unavailable, retrying in 30 s" {
+		t.Errorf("expected qBittorrent error, got %q", m.Text)
+	}
+}
+
+func TestPauseFailed(t *testing.T) {
+	b := backends.Start(t)
+	backends.FastRetries(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.DOWNLOADING, DownloadDir: common.Settings.Path.Default})
+
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+	b.Transmission.Inject(fault.Fault{Status: http.StatusBadGateway})
+	h.Press(h.Last(), "pause")
+	if m := h.Last(); !strings.Contains(m.Text, "Transmission") || m.Keyboard != nil {
+		t.Errorf("expected the error reply, got %q", m.Text)
+	}
+	if torrents := b.Transmission.Torrents(); torrents[0].Status != transmission.DOWNLOADING {
+		t.Errorf("the torrent must not be paused, got %v", torrents)
+	}
+}
+
+func TestListPauseDelete(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{
+		Name: "ubuntu.iso", AddedDate: time.Now().Add(time.Hour).Unix(), Status: transmission.SEEDING, PercentDone: 1, DownloadedEver: 6 << 30,
+		UploadRatio: 1.5, PeersGettingFromUs: 3, DownloadDir: common.Settings.Path.Default,
+		Files: []transmission.File{{Name: "ubuntu.iso", Length: 6 << 30}},
+	})
+	os.WriteFile(path.Join(common.Settings.Path.Movie, "movie.mkv"), []byte("movie"), 0o600)
+
+	h.Send("/downloads")
+	m := h.Last()
+	if !strings.Contains(m.Text, "ubuntu.iso [6.00 GB] [100%] [1.50x] [seeding:3p]") {
+		t.Errorf("torrent is not rendered in %q", m.Text)
+	}
+	if !strings.Contains(m.Text, "🎬movie.mkv [5 B] [0%] [0.00x] [unknown]") {
+		t.Errorf("unknown file is not rendered in %q", m.Text)
+	}
+
+	h.Press(m, "1")
+	h.Press(h.Last(), "pause")
+	if torrents := b.Transmission.Torrents(); torrents[0].Status != transmission.STOPPED {
+		t.Errorf("torrent is not paused")
+	}
+
+	h.Press(h.Last(), "2")
+	h.Press(h.Last(), "delete")
+	if _, err := os.Stat(path.Join(common.Settings.Path.Movie, "movie.mkv")); !os.IsNotExist(err) {
+		t.Errorf("unknown file is not deleted")
+	}
+	if m = h.Last(); !strings.Contains(m.Text, "results: 1-1 of 1") {
+		t.Errorf("deleted item is still listed in %q", m.Text)
+	}
+}
+
+func TestMultipleInstances(t *testing.T) {
+	b := backends.Start(t)
+	seedbox := b.AddTransmission(t, "seedbox")
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "nas.iso", Status: transmission.SEEDING, AddedDate: time.Now().Add(time.Hour).Unix()})
+	seedbox.AddTorrent(transmission.Torrent{Name: "seedbox.iso", Status: transmission.SEEDING})
+
+	h.Send("/downloads")
+	m := h.Last()
+	if !strings.Contains(m.Text, "nas.iso") || !strings.Contains(m.Text, "@transmission") || !strings.Contains(m.Text, "seedbox.iso") || !strings.Contains(m.Text, "@seedbox") {
+		t.Fatalf("torrents of both instances must be listed and labeled in %q", m.Text)
+	}
+
+	h.Press(m, "🔻")
+	h.Press(h.Last(), "seedbox")
+	if m = h.Last(); strings.Contains(m.Text, "nas.iso") || !strings.Contains(m.Text, "results: 1-1 of 1") {
+		t.Fatalf("expected only seedbox torrents in %q", m.Text)
+	}
+
+	h.Press(m, "🔺")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "pause")
+	if seedbox.Torrents()[0].Status != transmission.STOPPED || b.Transmission.Torrents()[0].Status != transmission.SEEDING {
+		t.Error("pause must go to the instance the torrent belongs to")
+	}
+}
+
+func TestOneInstanceDown(t *testing.T) {
+	b := backends.Start(t)
+	down := b.AddTransmission(t, "seedbox")
+	down.Close()
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "nas.iso", Status: transmission.SEEDING})
+
+	h.Send("/downloads")
+	if m := h.Last(); !strings.Contains(m.Text, "nas.iso") {
+		t.Errorf("available instance must be listed in %q", m.Text)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	mine := b.Transmission.AddTorrent(transmission.Torrent{Name: "mine.iso", Status: transmission.SEEDING})
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "foreign.iso", Status: transmission.SEEDING})
+	owners.Record(mine.HashString, owners.Owner{UserID: 2, UserName: "@alice", Target: "transmission"})
+	t.Cleanup(func() { owners.Forget(mine.HashString) })
+
+	h.Send("/downloads")
+	m := h.Last()
+	if !strings.Contains(m.Text, "mine.iso [0 B] [0%] [0.00x] [seeding:0p] 👤@alice") {
+		t.Fatalf("owner is not shown in %q", m.Text)
+	}
+
+	h.Press(m, "🔻")
+	h.Press(h.Last(), "@alice")
+	if m = h.Last(); strings.Contains(m.Text, "foreign.iso") || !strings.Contains(m.Text, "results: 1-1 of 1") {
+		t.Errorf("expected only torrents of @alice in %q", m.Text)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{
+		Name: "series", Status: transmission.DOWNLOADING,
+		Files: []transmission.File{
+			{Name: "series/s01e01.mkv", Length: 100, BytesCompleted: 50},
+			{Name: "series/s01e02.mkv", Length: 100},
+		},
+	})
+
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "files")
+	m := h.Last()
+	if len(h.Messages(h.ChatID)) != 2 {
+		t.Fatal("files must be shown in a separate message")
+	}
+	if !strings.Contains(m.Text, "🎬s01e01.mkv [100 B] [50%]") || !strings.Contains(m.Text, "2 of 2 files wanted, 200 B") {
+		t.Fatalf("unexpected files view %q", m.Text)
+	}
+
+	h.Press(m, "2")
+	h.Press(h.Last(), "skip")
+	h.Press(h.Last(), "high")
+	stats := b.Transmission.Torrents()[0].FileStats
+	if stats[1].Wanted || stats[1].Priority != 1 || !stats[0].Wanted {
+		t.Errorf("unexpected file stats %v", stats)
+	}
+	if m = h.Last(); !strings.Contains(m.Text, "s01e02.mkv [100 B] [0%] [skip] [high]") || !strings.Contains(m.Text, "1 of 2 files wanted") {
+		t.Errorf("changes are not rendered in %q", m.Text)
+	}
+	if _, ok := m.Button("download"); !ok {
+		t.Errorf("skipped file must be selected and offer download, got %v", m.Buttons())
+	}
+}
+
+func TestFilesWithoutLowPriority(t *testing.T) {
+	backends.Start(t)
+	s := qbittorrent.NewServer()
+	t.Cleanup(s.Close)
+	c, _ := apiqbittorrent.New(s.URL, qbittorrent.USERNAME, qbittorrent.PASSWORD, nil)
+	clients := client.NewClients()
+	clients.Set("seedbox", c)
+	h := newHarness(t, clients)
+	s.AddTorrent(qbittorrent.Torrent{
+		Name:  "series",
+		Files: []qbittorrent.File{{Name: "series/s01e01.mkv", Size: 100, Priority: 0}},
+	})
+
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "files")
+	h.Press(h.Last(), "1")
+	m := h.Last()
+	if _, ok := m.Button("low"); ok {
+		t.Errorf("qBittorrent has no low priority, got %v", m.Buttons())
+	}
+	h.Press(m, "high")
+	if m = h.Last(); !strings.Contains(m.Text, "s01e01.mkv [100 B] [0%] [high]") || strings.Contains(m.Text, "[skip]") {
+		t.Errorf("the file must be shown as the client has it, got %q", m.Text)
+	}
+}
+
+// waitFor returns the first message containing the text, the background jobs report that way
+func waitFor(t *testing.T, h *telegram.Harness, text string) telegram.Message {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, m := range h.Messages(h.ChatID) {
+			if strings.Contains(m.Text, text) {
+				return m
+			}
+		}
+	}
+	t.Fatalf("no message with %q in %v", text, h.Messages(h.ChatID))
+	return telegram.Message{}
+}
+
+func TestMove(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{
+		Name: "ubuntu.iso", Status: transmission.SEEDING, DownloadDir: common.Settings.Path.Default, AddedDate: time.Now().Add(time.Hour).Unix(),
+	})
+	os.Mkdir(path.Join(common.Settings.Path.Movie, "movie"), 0o700)
+	os.WriteFile(path.Join(common.Settings.Path.Movie, "movie", "movie.mkv"), []byte("movie"), 0o600)
+
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "move to…")
+	m := h.Last()
+	if _, ok := m.Button("move:default"); ok {
+		t.Errorf("the current path must not be offered, got %v", m.Buttons())
+	}
+	h.Press(m, "move:series")
+	waitFor(t, h, "✅ moved <b>ubuntu.iso</b> to series")
+	if dir := b.Transmission.Torrents()[0].DownloadDir; dir != common.Settings.Path.Series {
+		t.Errorf("torrent is not moved, it is in %s", dir)
+	}
+
+	conflict := path.Join(common.Settings.Path.Default, "movie")
+	os.Mkdir(conflict, 0o700)
+	os.Chtimes(conflict, time.Unix(0, 0), time.Unix(0, 0)) // listed last
+	h.Press(h.Messages(h.ChatID)[0], "2")
+	h.Press(h.Messages(h.ChatID)[0], "move to…")
+	h.Press(h.Messages(h.ChatID)[0], "move:default")
+	waitFor(t, h, "❌ move of <b>movie</b> to default failed")
+
+	h.Press(h.Messages(h.ChatID)[0], "2")
+	h.Press(h.Messages(h.ChatID)[0], "move to…")
+	h.Press(h.Messages(h.ChatID)[0], "move:series")
+	waitFor(t, h, "✅ moved <b>movie</b> to series")
+	if _, err := os.Stat(path.Join(common.Settings.Path.Series, "movie", "movie.mkv")); err != nil {
+		t.Errorf("directory is not moved: %v", err)
+	}
+}
+
+// fastUpdates makes the list refresh often, the refreshes are waited for at the end of the test
+// as they read the settings the next test writes
+func fastUpdates(t *testing.T) {
+	interval, updater := updateInterval, Updater
+	var wg sync.WaitGroup
+	updateInterval = 10 * time.Millisecond
+	Updater = func(ctx context.Context, chatID int64, p *ListPaginator) {
+		wg.Add(1)
+		defer wg.Done()
+		updater(ctx, chatID, p)
+	}
+	t.Cleanup(func() {
+		wg.Wait() // the harness cleanup registered later has canceled the sessions already
+		updateInterval, Updater = interval, updater
+	})
+}
+
+func TestMoveAfterReload(t *testing.T) {
+	fastUpdates(t)
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.SEEDING, DownloadDir: common.Settings.Path.Default})
+
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "move to…")
+	time.Sleep(100 * time.Millisecond) // a few reloads
+	m := h.Last()
+	if _, ok := m.Button("move:series"); !ok {
+		t.Fatalf("the targets must survive reload, got %v", m.Buttons())
+	}
+	h.Press(m, "move:series")
+	waitFor(t, h, "✅ moved <b>ubuntu.iso</b> to series")
+}
+
+// stuck accepts the moves but never does them
+type stuck struct {
+	client.DownloadClient
+}
+
+func (stuck) Move(ctx context.Context, hash string, dir string) error {
+	return nil
+}
+
+func TestMoveTimeout(t *testing.T) {
+	poll, timeout := movePoll, moveTimeout
+	movePoll, moveTimeout = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { movePoll, moveTimeout = poll, timeout })
+	b := backends.Start(t)
+	c, _ := b.Clients.Get("transmission")
+	b.Clients.Set("transmission", stuck{c})
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.SEEDING, DownloadDir: common.Settings.Path.Default})
+
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "move to…")
+	h.Press(h.Last(), "move:series")
+	m := waitFor(t, h, "❌ move of <b>ubuntu.iso</b> to series failed")
+	if !strings.Contains(m.Text, "is not seen in "+common.Settings.Path.Series) {
+		t.Errorf("unexpected report %q", m.Text)
+	}
+}
+
+func TestCategoryFilter(t *testing.T) {
+	b := backends.Start(t)
+	common.Settings.CategoryList = []common.Category{
+		{Name: "default", Path: common.Settings.Path.Default},
+		{Name: "4k", Path: t.TempDir(), Icon: "🎞", Labels: []string{"uhd"}},
+	}
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "labeled.mkv", Labels: []string{"uhd"}, DownloadDir: "/elsewhere"})
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "plain.iso", DownloadDir: common.Settings.Path.Default})
+	os.WriteFile(path.Join(common.Settings.CategoryList[1].Path, "scanned.mkv"), []byte("movie"), 0o600)
+	os.WriteFile(path.Join(common.Settings.Path.Movie, "legacy.mkv"), []byte("movie"), 0o600)
+
+	h.Send("/downloads")
+	m := h.Last()
+	if !strings.Contains(m.Text, "scanned.mkv") || strings.Contains(m.Text, "legacy.mkv") {
+		t.Fatalf("the category paths must be scanned instead of the legacy ones in %q", m.Text)
+	}
+	h.Press(m, "🔻")
+	h.Press(h.Last(), "🎞4k")
+	if m = h.Last(); !strings.Contains(m.Text, "labeled.mkv") || !strings.Contains(m.Text, "scanned.mkv") || strings.Contains(m.Text, "plain.iso") {
+		t.Errorf("expected only 4k items in %q", m.Text)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	fastUpdates(t)
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.SEEDING})
+	h.Send("/downloads")
+	time.Sleep(50 * time.Millisecond) // a few updates
+
+	paginator.Expire(time.Now())
+	m := h.Last()
+	if len(m.Keyboard) != 0 || !strings.Contains(m.Text, "⌛ expired") {
+		t.Errorf("expired list must lose its keyboard, got %q %v", m.Text, m.Buttons())
+	}
+	time.Sleep(20 * time.Millisecond) // the update in flight, if any
+	hits := b.Transmission.Hits()
+	time.Sleep(50 * time.Millisecond)
+	if b.Transmission.Hits() != hits {
+		t.Errorf("the updater must stop polling on expiry, %d more requests", b.Transmission.Hits()-hits)
+	}
+}
+
+func TestSelectionFollowsItem(t *testing.T) {
+	fastUpdates(t)
+	b := backends.Start(t)
+	h := newHarness(t, b.Clients)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.SEEDING, AddedDate: time.Now().Add(-time.Hour).Unix()})
+	h.Send("/downloads")
+	h.Press(h.Last(), "1")
+
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "debian.iso", Status: transmission.SEEDING, AddedDate: time.Now().Unix()})
+	m := waitFor(t, h, "debian.iso") // listed first as the newest one
+	h.Press(m, "delete")
+	if torrents := b.Transmission.Torrents(); len(torrents) != 1 || torrents[0].Name != "debian.iso" {
+		t.Errorf("the selected torrent must be deleted, left %v", torrents)
+	}
+}
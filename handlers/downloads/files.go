@@ -0,0 +1,225 @@
+package downloads
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
+
+	"torrentino/api/client"
+	"torrentino/common/paginator"
+	"torrentino/common/utils"
+)
+
+type FileItem struct {
+	client.File
+	Index int // position in the torrent, the clients address files by it
+}
+
+// FilesPaginator is the drill-down view of a torrent from /downloads
+type FilesPaginator struct {
+	paginator.Paginator
+	clients  *client.Clients
+	instance string
+	hash     string
+	name     string
+}
+
+// ----------------------------------------
+func NewFilesPaginator(ctx context.Context, b *bot.Bot, update *models.Update, clients *client.Clients, instance string, hash string) *FilesPaginator {
+	var p FilesPaginator
+	p = FilesPaginator{
+		*paginator.New(ctx, b, update, "files", 8, &p, &p, &p),
+		clients,
+		instance,
+		hash,
+		"",
+	}
+	p.SetupSorting([]paginator.Sorting{
+		{Attribute: "Name", Alias: "name", Order: 2},
+		{Attribute: "Length", Alias: "size", Order: 0},
+	})
+	p.SetupFiltering([]string{"Wanted"})
+	return &p
+}
+
+// paginator.Querier
+func (p *FilesPaginator) Query() string {
+	return p.hash + "@" + p.instance
+}
+
+func (p *FilesPaginator) Item(i int) *FileItem {
+	return p.Paginator.Item(i).(*FileItem)
+}
+
+// paginator.Identifier
+func (p *FilesPaginator) ID(i int) string {
+	return strconv.Itoa(p.Item(i).Index)
+}
+
+// method overload
+func (p *FilesPaginator) Header() string {
+	return "<b>" + p.name + "</b>\n" + p.Paginator.Header()
+}
+
+// method overload
+func (p *FilesPaginator) Line(i int) string {
+	item := p.Item(i)
+	percent := float64(0)
+	if item.Length > 0 {
+		percent = float64(item.BytesCompleted) / float64(item.Length) * 100
+	}
+	result := ExtIcons[strings.ToLower(filepath.Ext(item.Name))] +
+		strings.TrimPrefix(item.Name, p.name+"/") +
+		" [" + utils.FormatFileSize(uint64(item.Length)) + "]" +
+		" [" + fmt.Sprintf("%.0f", percent) + "%]"
+	if !item.Wanted {
+		result += " [skip]"
+	}
+	switch item.Priority {
+	case client.PRIORITY_HIGH:
+		result += " [high]"
+	case client.PRIORITY_LOW:
+		result += " [low]"
+	}
+	return result
+}
+
+// method overload
+func (p *FilesPaginator) Footer() string {
+	var wanted, size int64
+	for i := range p.Len() {
+		if item := p.Item(i); item.Wanted {
+			wanted++
+			size += item.Length
+		}
+	}
+	return fmt.Sprintf("%d of %d files wanted, ", wanted, p.Len()) + utils.FormatFileSize(uint64(size))
+}
+
+// method overload
+func (p *FilesPaginator) Stringify(i int, attribute string) string {
+	if attribute == "Wanted" {
+		if p.Item(i).Wanted {
+			return "wanted"
+		}
+		return "skipped"
+	}
+	return ""
+}
+
+// method overload
+func (p *FilesPaginator) Compare(i int, j int, attribute string) bool {
+	a := p.Item(i)
+	b := p.Item(j)
+	switch attribute {
+	case "Name":
+		return a.Name < b.Name
+	case "Length":
+		return a.Length < b.Length
+	}
+	return false
+}
+
+// method overload
+func (p *FilesPaginator) Actions(i int) (result []string) {
+	item := p.Item(i)
+	if item.Wanted {
+		result = append(result, "skip")
+	} else {
+		result = append(result, "download")
+	}
+	c, ok := p.clients.Get(p.instance)
+	if !ok {
+		return result
+	}
+	for _, priority := range client.Priorities(c) {
+		if priority != item.Priority {
+			result = append(result, priorityNames[priority])
+		}
+	}
+	return result
+}
+
+var priorityNames = map[int]string{
+	client.PRIORITY_HIGH:   "high",
+	client.PRIORITY_NORMAL: "normal",
+	client.PRIORITY_LOW:    "low",
+}
+
+// method overload
+func (p *FilesPaginator) Execute(i int, action string) (unselect bool) {
+	item := p.Item(i)
+	c, ok := p.clients.Get(p.instance)
+	if !ok {
+		utils.LogError(errors.New("download client " + p.instance + " is not configured"))
+		return true
+	}
+	var err error
+	switch action {
+	case "skip", "download":
+		err = c.SetWanted(p.Context(), p.hash, []int{item.Index}, action == "download")
+	default:
+		for priority, name := range priorityNames {
+			if name == action {
+				err = c.SetPriority(p.Context(), p.hash, []int{item.Index}, priority)
+			}
+		}
+	}
+	if err == nil { // what the client has made of it, e.g. qBittorrent makes the file wanted on any priority
+		var files []client.File
+		if files, err = c.Files(p.Context(), p.hash); err == nil && item.Index < len(files) {
+			item.File = files[item.Index]
+		}
+	}
+	if err != nil {
+		utils.LogError(errors.Wrap(err, p.instance))
+	}
+	return false // keep the file selected to toggle it once again
+}
+
+func (p *FilesPaginator) Reload() error {
+	c, ok := p.clients.Get(p.instance)
+	if !ok {
+		return errors.New("download client " + p.instance + " is not configured")
+	}
+	files, err := c.Files(p.Context(), p.hash)
+	if err != nil {
+		utils.LogError(err)
+		return err
+	}
+	name := ""
+	if torrents, err := c.List(p.Context()); err == nil {
+		for _, t := range torrents {
+			if t.Hash == p.hash {
+				name = t.Name
+			}
+		}
+	}
+	p.Locked(func() {
+		p.name = name
+		p.Alloc(len(files))
+		for i := range files {
+			p.Append(&FileItem{files[i], i})
+		}
+	})
+	return nil
+}
+
+// NewFilesRestorer rehydrates the files view after restart, see paginator.RegisterRestorer
+func NewFilesRestorer(clients *client.Clients) paginator.Restorer {
+	return func(ctx context.Context, b *bot.Bot, state *paginator.State) (*paginator.Paginator, error) {
+		hash, instance, _ := strings.Cut(state.Query, "@")
+		p := NewFilesPaginator(ctx, b, nil, clients, instance, hash)
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+		p.Restore(state)
+		return &p.Paginator, nil
+	}
+}
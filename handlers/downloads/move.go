@@ -0,0 +1,137 @@
+package downloads
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
+
+	"torrentino/common"
+	"torrentino/common/utils"
+)
+
+// the move of a torrent is checked that often, the progress message is edited not more often
+var movePoll = 2 * time.Second
+
+// moveTimeout limits the wait for the client to report the new place, e.g. when its paths
+// are mapped differently the torrent would never be seen there
+var moveTimeout = 2 * time.Hour
+
+type moveTarget struct {
+	Name string
+	Dir  string
+}
+
+// moveTargets are the paths of the categories, except the one the item is in
+func moveTargets(item *ListItem) (result []moveTarget) {
+	for _, category := range common.Current().Categories() {
+		if category.Path != "" && filepath.Clean(category.Path) != filepath.Clean(item.DownloadDir) {
+			result = append(result, moveTarget{category.Name, category.Path})
+		}
+	}
+	return result
+}
+
+// progress is the message reporting a long operation, it is edited in place
+type progress struct {
+	ctx     context.Context
+	b       *bot.Bot
+	message *models.Message
+	edited  time.Time
+}
+
+func newProgress(ctx context.Context, b *bot.Bot, chatID int64, text string) *progress {
+	message, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: chatID, Text: text, ParseMode: models.ParseModeHTML})
+	if err != nil {
+		utils.LogError(err)
+	}
+	return &progress{ctx: ctx, b: b, message: message, edited: time.Now()}
+}
+
+// update edits the message, but not more often than movePoll unless final
+func (r *progress) update(text string, final bool) {
+	if r.message == nil || !final && time.Since(r.edited) < movePoll {
+		return
+	}
+	r.edited = time.Now()
+	_, err := r.b.EditMessageText(r.ctx, &bot.EditMessageTextParams{
+		ChatID:    r.message.Chat.ID,
+		MessageID: r.message.ID,
+		Text:      text,
+		ParseMode: models.ParseModeHTML,
+	})
+	if err != nil {
+		utils.LogError(err)
+	}
+}
+
+// move relocates the item in background, the torrents are moved by their clients,
+// the files unknown to any client are moved on disk
+func (p *ListPaginator) move(item ListItem, target moveTarget, chatID int64) {
+	ctx := context.WithoutCancel(p.Context())
+	title := "<b>" + item.Name + "</b> to " + target.Name
+	report := newProgress(ctx, p.Bot(), chatID, "⏳ moving "+title)
+	var err error
+	if item.Client != "" {
+		err = p.moveTorrent(ctx, &item, target.Dir, func(elapsed time.Duration) {
+			report.update("⏳ moving "+title+"\nelapsed: "+elapsed.Round(time.Second).String(), false)
+		})
+	} else {
+		err = utils.MoveAll(path.Join(item.DownloadDir, item.Name), path.Join(target.Dir, item.Name), func(done int64, total int64) {
+			report.update(fmt.Sprintf("⏳ moving %s\n%s of %s", title, utils.FormatFileSize(uint64(done)), utils.FormatFileSize(uint64(total))), false)
+		})
+	}
+	if p.Reload() == nil { // before the final report, so the list is up to date once it's seen
+		p.Show()
+	}
+	if err != nil {
+		utils.LogError(err)
+		report.update("❌ move of "+title+" failed: "+err.Error(), true)
+	} else {
+		report.update("✅ moved "+title, true)
+	}
+}
+
+// moveTorrent asks the client to move the data and waits until the torrent is seen at the new place
+func (p *ListPaginator) moveTorrent(ctx context.Context, item *ListItem, dir string, tick func(elapsed time.Duration)) error {
+	c, ok := p.clients.Get(item.Client)
+	if !ok {
+		return errors.New("download client " + item.Client + " is not configured")
+	}
+	if err := c.Move(ctx, item.Hash, dir); err != nil {
+		return errors.Wrap(err, item.Client)
+	}
+	start := time.Now()
+	for {
+		torrents, err := c.List(ctx)
+		if err != nil {
+			return errors.Wrap(err, item.Client)
+		}
+		found := false
+		for _, t := range torrents {
+			if t.Hash != item.Hash {
+				continue
+			}
+			found = true
+			switch {
+			case t.Error != "":
+				return errors.New(item.Client + ": " + t.Error)
+			case filepath.Clean(t.DownloadDir) == filepath.Clean(dir):
+				return nil
+			}
+		}
+		if !found {
+			return errors.New(item.Client + ": torrent " + item.Hash + " is gone")
+		}
+		if time.Since(start) >= moveTimeout {
+			return errors.New(item.Client + ": torrent is not seen in " + dir + " after " + moveTimeout.String())
+		}
+		tick(time.Since(start))
+		time.Sleep(movePoll)
+	}
+}
@@ -0,0 +1,235 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	gotorrentparser "github.com/j-muller/go-torrent-parser"
+
+	"torrentino/api/resilience"
+	"torrentino/common/paginator"
+	"torrentino/common/utils"
+)
+
+type PickItem struct {
+	Path    string
+	Length  int64
+	Index   int // position in the torrent, the clients address files by it
+	Checked bool
+}
+
+// PickPaginator lets to choose the files of a .torrent before it is added to a download client
+type PickPaginator struct {
+	paginator.Paginator
+	backends Backends
+	item     ListItem
+	query    string
+	added    string // the instance the torrent was added to, no more actions after that
+}
+
+// pickState is saved as the session query, so the view survives restarts
+type pickState struct {
+	Link      string
+	Title     string
+	TrackerId string
+	InfoHash  string
+	Query     string
+	Unchecked []int `json:",omitempty"`
+}
+
+// ----------------------------------------
+func NewPickPaginator(ctx context.Context, b *bot.Bot, update *models.Update, backends Backends, item ListItem, query string) *PickPaginator {
+	var p PickPaginator
+	p = PickPaginator{
+		*paginator.New(ctx, b, update, "pick", 8, &p, &p, &p),
+		backends,
+		item,
+		query,
+		"",
+	}
+	p.SetupSorting([]paginator.Sorting{
+		{Attribute: "Path", Alias: "name", Order: 2},
+		{Attribute: "Length", Alias: "size", Order: 0},
+	})
+	return &p
+}
+
+// paginator.Querier
+func (p *PickPaginator) Query() string {
+	state := pickState{p.item.Link, p.item.Title, p.item.TrackerId, p.item.InfoHash, p.query, nil}
+	for i := range p.Len() {
+		if item := p.Item(i); !item.Checked {
+			state.Unchecked = append(state.Unchecked, item.Index)
+		}
+	}
+	data, _ := json.Marshal(state)
+	return string(data)
+}
+
+func (p *PickPaginator) Item(i int) *PickItem {
+	return p.Paginator.Item(i).(*PickItem)
+}
+
+// paginator.Identifier
+func (p *PickPaginator) ID(i int) string {
+	return strconv.Itoa(p.Item(i).Index)
+}
+
+// method overload
+func (p *PickPaginator) Header() string {
+	header := "<b>" + p.item.Title + "</b>\n"
+	if p.added != "" {
+		header += "added to " + p.added + "\n"
+	}
+	return header + p.Paginator.Header()
+}
+
+// method overload
+func (p *PickPaginator) Line(i int) string {
+	item := p.Item(i)
+	check := "⬜ "
+	if item.Checked {
+		check = "☑️ "
+	}
+	return check + item.Path + " [" + utils.FormatFileSize(uint64(item.Length)) + "]"
+}
+
+// method overload
+func (p *PickPaginator) Footer() string {
+	var checked, size int64
+	for i := range p.Len() {
+		if item := p.Item(i); item.Checked {
+			checked++
+			size += item.Length
+		}
+	}
+	return fmt.Sprintf("%d of %d files selected, ", checked, p.Len()) + utils.FormatFileSize(uint64(size))
+}
+
+// method overload
+func (p *PickPaginator) Stringify(i int, attribute string) string {
+	return ""
+}
+
+// method overload
+func (p *PickPaginator) Compare(i int, j int, attribute string) bool {
+	a := p.Item(i)
+	b := p.Item(j)
+	switch attribute {
+	case "Path":
+		return a.Path < b.Path
+	case "Length":
+		return a.Length < b.Length
+	}
+	return false
+}
+
+// method overload
+func (p *PickPaginator) Actions(i int) (result []string) {
+	if p.added != "" {
+		return nil
+	}
+	if p.Item(i).Checked {
+		result = append(result, "skip")
+	} else {
+		result = append(result, "pick")
+	}
+	return append(append(result, "all", "none"), p.backends.downloadActions()...)
+}
+
+// method overload
+func (p *PickPaginator) Execute(i int, action string) (unselect bool) {
+	switch action {
+	case "skip", "pick":
+		p.Item(i).Checked = action == "pick"
+		return false // keep the file selected to toggle it once again
+	case "all", "none":
+		for j := range p.Len() {
+			p.Item(j).Checked = action == "all"
+		}
+		return false
+	}
+	action, instance, _ := strings.Cut(action, "@")
+	category, ok := downloadCategory(action)
+	if !ok {
+		return false
+	}
+	var unwanted []int
+	for j := range p.Len() {
+		if item := p.Item(j); !item.Checked {
+			unwanted = append(unwanted, item.Index)
+		}
+	}
+	if len(unwanted) == p.Len() {
+		p.ReplyMessage("no files selected")
+		return false
+	}
+	name, err := p.backends.download(p.Context(), &p.Paginator, p.query, &p.item, instance, p.item.Link, category, unwanted)
+	if err != nil {
+		utils.LogError(err)
+		p.ReplyMessage(resilience.Message(err))
+		return false
+	}
+	p.added = name
+	return true
+}
+
+// load fills the list with the files of the parsed torrent, all are checked but the unchecked indexes
+func (p *PickPaginator) load(torrent *gotorrentparser.Torrent, unchecked []int) {
+	if p.item.InfoHash == "" {
+		p.item.InfoHash = torrent.InfoHash
+	}
+	p.Alloc(len(torrent.Files))
+	for i, f := range torrent.Files {
+		path := f.Path
+		if len(torrent.Files) > 1 && len(path) > 1 { // the first element is the torrent name
+			path = path[1:]
+		}
+		checked := true
+		for _, index := range unchecked {
+			if index == i {
+				checked = false
+			}
+		}
+		p.Append(&PickItem{strings.Join(path, "/"), f.Length, i, checked})
+	}
+}
+
+func (p *PickPaginator) Reload() error {
+	torrent, err := p.backends.Jackett.GetTorrent(p.Context(), p.item.Link)
+	if err != nil {
+		utils.LogError(err)
+		return err
+	}
+	p.Locked(func() {
+		p.load(torrent, nil)
+	})
+	return nil
+}
+
+// NewPickRestorer rehydrates the file picker after restart, see paginator.RegisterRestorer
+func NewPickRestorer(backends Backends) paginator.Restorer {
+	return func(ctx context.Context, b *bot.Bot, state *paginator.State) (*paginator.Paginator, error) {
+		var s pickState
+		if err := json.Unmarshal([]byte(state.Query), &s); err != nil {
+			return nil, err
+		}
+		item := ListItem{}
+		item.Link, item.Title, item.TrackerId, item.InfoHash = s.Link, s.Title, s.TrackerId, s.InfoHash
+		p := NewPickPaginator(ctx, b, nil, backends, item, s.Query)
+		torrent, err := backends.Jackett.GetTorrent(ctx, s.Link)
+		if err != nil {
+			return nil, err
+		}
+		p.Locked(func() {
+			p.load(torrent, s.Unchecked)
+		})
+		p.Restore(state)
+		return &p.Paginator, nil
+	}
+}
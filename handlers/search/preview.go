@@ -0,0 +1,190 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+
+	"torrentino/api/resilience"
+	"torrentino/api/torrserver"
+	"torrentino/common/utils"
+)
+
+// MAX_PREVIEW_FILES keeps the reply short, MAX_MESSAGE_LENGTH is the telegram limit of a message text
+const MAX_PREVIEW_FILES = 50
+const MAX_MESSAGE_LENGTH = 4096
+
+// metadata of magnets is waited for that long
+var metadataTimeout = 20 * time.Second
+var metadataPoll = 500 * time.Millisecond
+
+type PreviewFile struct {
+	Path   string // relative to the torrent name
+	Length int64
+}
+
+// Preview is the contents of a torrent as shown by "files" action of the search results
+type Preview struct {
+	Name        string
+	Files       []PreviewFile
+	PieceLength int64 // known only for .torrent files, as well as Private
+	Private     bool
+	Trackers    []string
+	Source      string // where the metadata of a magnet came from
+}
+
+// preview replies with the files of the search result, it runs in the background without the list lock
+func (p *FindPaginator) preview(item ListItem) {
+	ctx := context.WithoutCancel(p.Context())
+	var pv *Preview
+	var err error
+	if item.Link != "" {
+		pv, err = p.backends.previewTorrent(ctx, item.Link)
+	} else {
+		pv, err = p.backends.previewMagnet(ctx, item.MagnetUri)
+	}
+	if err != nil {
+		utils.LogError(err)
+		p.ReplyMessage(resilience.Message(err))
+		return
+	}
+	p.ReplyMessage(pv.String())
+}
+
+// previewTorrent parses the .torrent file
+func (backends Backends) previewTorrent(ctx context.Context, link string) (*Preview, error) {
+	info, err := backends.Jackett.GetTorrentInfo(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	pv := &Preview{PieceLength: info.PieceLength, Private: info.Private, Trackers: info.Announce}
+	for _, f := range info.Files {
+		path := f.Path
+		if len(info.Files) > 1 && len(path) > 1 { // the first element is the torrent name
+			pv.Name, path = path[0], path[1:]
+		}
+		pv.Files = append(pv.Files, PreviewFile{strings.Join(path, "/"), f.Length})
+	}
+	if pv.Name == "" && len(pv.Files) == 1 {
+		pv.Name = pv.Files[0].Path
+	}
+	return pv, nil
+}
+
+// previewMagnet fetches the metadata of a magnet through TorrServer, the torrent is preloaded and removed
+// afterwards unless it has been there before. The download clients are of no use here: the torrent added
+// paused does not fetch its metadata and the started one downloads the data at once
+func (backends Backends) previewMagnet(ctx context.Context, magnet string) (*Preview, error) {
+	list, err := backends.Torrserver.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "the files of magnets are fetched through TorrServer")
+	}
+	status, err := backends.Torrserver.Preload(ctx, magnet, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "TorrServer")
+	}
+	hash := status.Hash // as the server knows it, the magnet may carry it in base32 or not at all
+	if !slices.ContainsFunc(*list, func(t torrserver.TSListItem) bool { return t.Hash == hash }) {
+		defer func() { // clean up even if the search is gone
+			if err := backends.Torrserver.Delete(context.WithoutCancel(ctx), hash); err != nil {
+				utils.LogError(err)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(metadataTimeout); len(status.FileStats) == 0; {
+		if time.Now().After(deadline) {
+			return nil, errors.New("TorrServer: metadata timeout")
+		}
+		time.Sleep(metadataPoll)
+		if status, err = backends.Torrserver.Get(ctx, hash); err != nil {
+			return nil, errors.Wrap(err, "TorrServer")
+		}
+	}
+	pv := &Preview{Name: status.Name, Trackers: magnetTrackers(magnet), Source: "TorrServer"}
+	for _, f := range status.FileStats {
+		pv.Files = append(pv.Files, PreviewFile{strings.TrimPrefix(f.Path, status.Name+"/"), f.Length})
+	}
+	return pv, nil
+}
+
+func magnetTrackers(magnet string) []string {
+	u, err := url.Parse(magnet)
+	if err != nil {
+		return nil
+	}
+	return u.Query()["tr"]
+}
+
+// textLength is the length of the text as telegram counts it, in UTF-16 units, the markup is counted too to be safe
+func textLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// tree renders the files grouped by directories within budget characters
+func (pv *Preview) tree(budget int) (lines []string) {
+	files := slices.Clone(pv.Files)
+	slices.SortFunc(files, func(a, b PreviewFile) int { return strings.Compare(a.Path, b.Path) })
+	var prev []string
+	for i, f := range files {
+		dirs := strings.Split(f.Path, "/")
+		name := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		same := 0
+		for same < len(dirs) && same < len(prev) && dirs[same] == prev[same] {
+			same++
+		}
+		var entry []string
+		for j := same; j < len(dirs); j++ {
+			entry = append(entry, strings.Repeat("  ", j)+"📁 "+html.EscapeString(dirs[j]))
+		}
+		entry = append(entry, strings.Repeat("  ", len(dirs))+html.EscapeString(name)+" ["+utils.FormatFileSize(uint64(f.Length))+"]")
+		more := fmt.Sprintf("… %d more files", len(files)-i)
+		length := textLength(strings.Join(entry, "\n")) + 1
+		if i == MAX_PREVIEW_FILES || (i < len(files)-1 && length+textLength(more)+1 > budget) || length > budget {
+			lines = append(lines, more)
+			break
+		}
+		budget -= length
+		lines = append(lines, entry...)
+		prev = dirs
+	}
+	return lines
+}
+
+func (pv *Preview) String() string {
+	var total int64
+	for _, f := range pv.Files {
+		total += f.Length
+	}
+	head := "<b>" + html.EscapeString(pv.Name) + "</b>\n"
+	text := "\n\n" + fmt.Sprintf("files: %d, total: %s", len(pv.Files), utils.FormatFileSize(uint64(total)))
+	if pv.Source == "" {
+		text += "\npiece size: " + utils.FormatFileSize(uint64(pv.PieceLength))
+		if pv.Private {
+			text += "\nprivate: yes"
+		} else {
+			text += "\nprivate: no"
+		}
+	} else {
+		text += "\nmetadata from: " + pv.Source
+	}
+	for i, tracker := range pv.Trackers {
+		line := "\n " + html.EscapeString(tracker)
+		if i == 0 {
+			line = "\ntrackers:" + line
+		}
+		if textLength(head+text+line) > MAX_MESSAGE_LENGTH/2 { // the files are more important
+			text += fmt.Sprintf("\n … %d more", len(pv.Trackers)-i)
+			break
+		}
+		text += line
+	}
+	return head + strings.Join(pv.tree(MAX_MESSAGE_LENGTH-textLength(head+text)), "\n") + text
+}
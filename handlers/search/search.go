@@ -1,21 +1,31 @@
 package search
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/antchfx/htmlquery"
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
 
+	"torrentino/api/client"
 	"torrentino/api/jackett"
+	"torrentino/api/resilience"
 	"torrentino/api/torrserver"
-	"torrentino/api/transmission"
 	"torrentino/common"
+	"torrentino/common/owners"
 	"torrentino/common/paginator"
 	"torrentino/common/utils"
+	"torrentino/handlers/watcher"
 )
 
 type ListItem struct {
@@ -24,29 +34,56 @@ type ListItem struct {
 	InTorrserver bool
 }
 
+// Backends are the services the search talks to
+type Backends struct {
+	Jackett    *jackett.Client
+	Torrserver *torrserver.Client
+	Clients    *client.Clients
+}
+
 type FindPaginator struct {
 	paginator.Paginator
+	backends           Backends
 	query              string
 	transmissionHashes map[string]bool
 	torrserverHashes   map[string]bool
 }
 
 // ----------------------------------------
-func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update) *FindPaginator {
+func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update, backends Backends, query string) *FindPaginator {
 	var p FindPaginator
 	p = FindPaginator{
 		*paginator.New(ctx, b, update, "find", 4, &p, &p, &p),
-		update.Message.Text,
+		backends,
+		query,
 		make(map[string]bool),
 		make(map[string]bool),
 	}
+	p.SetupSorting([]paginator.Sorting{
+		{Attribute: "Size", Alias: "size", Order: 1},
+		{Attribute: "Seeders", Alias: "seeds", Order: 1},
+		{Attribute: "Peers", Alias: "peers", Order: 0},
+		{Attribute: "Link", Alias: "file", Order: 0},
+	})
+	p.SetupFiltering([]string{"TrackerId"})
 	return &p
 }
 
+// paginator.Querier
+func (p *FindPaginator) Query() string {
+	return p.query
+}
+
 func (p *FindPaginator) Item(i int) *ListItem {
 	return p.Paginator.Item(i).(*ListItem)
 }
 
+// paginator.Identifier, the same torrent may come from several trackers
+func (p *FindPaginator) ID(i int) string {
+	item := p.Item(i)
+	return item.TrackerId + " " + item.Guid + " " + item.Link + " " + item.MagnetUri
+}
+
 // method overload
 func (p *FindPaginator) Line(i int) string {
 
@@ -115,7 +152,7 @@ func (p *FindPaginator) Actions(i int) (result []string) {
 	item := p.Item(i)
 
 	if item.InfoHash == "" && item.Link != "" {
-		infoHash, err := jackett.GetInfoHash(item.Link)
+		infoHash, err := p.backends.Jackett.GetInfoHash(p.Context(), item.Link)
 		if err != nil {
 			utils.LogError(err)
 		} else {
@@ -131,13 +168,17 @@ func (p *FindPaginator) Actions(i int) (result []string) {
 	}
 
 	if !item.InTorrents {
-		result = append(result, "download")
-		result = append(result, "download:series")
-		result = append(result, "download:movie")
+		result = append(result, p.backends.downloadActions()...)
+		if item.Link != "" {
+			result = append(result, "pick files")
+		}
 	}
 	if !item.InTorrserver {
 		result = append(result, "torrsrv")
 	}
+	if item.Link != "" || item.MagnetUri != "" {
+		result = append(result, "files")
+	}
 	if item.Link != "" {
 		result = append(result, ".torrent")
 	}
@@ -160,34 +201,42 @@ func (p *FindPaginator) Execute(i int, action string) (unselect bool) {
 	}
 
 	var err error
+	action, instance, _ := strings.Cut(action, "@")
 	switch action {
-	case "download":
-		if _, err = transmission.Add(urlOrMagnet, common.Settings.Path.Default); err == nil {
-			item.InTorrents = true
-		}
-	case "download:series":
-		if _, err = transmission.Add(urlOrMagnet, common.Settings.Path.Series); err == nil {
-			item.InTorrents = true
-		}
-	case "download:movie":
-		if _, err = transmission.Add(urlOrMagnet, common.Settings.Path.Movie); err == nil {
-			item.InTorrents = true
+	case "pick files":
+		pick := NewPickPaginator(context.WithoutCancel(p.Context()), p.Bot(), p.Reply(), p.backends, *item, p.query)
+		if err = pick.Reload(); err == nil {
+			pick.Show()
 		}
 	case "torrsrv":
-		if err = torrserver.Add(urlOrMagnet, item.Title, getPosterLinkFromPage(item.Details, item.TrackerId)); err == nil {
+		if err = p.backends.Torrserver.Add(p.Context(), urlOrMagnet, item.Title, getPosterLinkFromPage(p.Context(), item.Details, item.TrackerId)); err == nil {
 			item.InTorrserver = true
+			hash := item.InfoHash
+			if hash == "" {
+				hash = client.MagnetHash(urlOrMagnet)
+			}
+			recordOwner(&p.Paginator, p.query, hash, item, "torrserver")
 		}
+	case "files": // the metadata of a magnet takes a while, the list keeps working meanwhile
+		go p.preview(*item)
 	case "web page":
 		p.ReplyMessage(item.Details)
 
 	case ".torrent":
-		var res *http.Response
-		if res, err = http.Get(item.Link); err == nil {
-			p.ReplyDocument(&models.InputFileUpload{Filename: item.Title + ".torrent", Data: res.Body})
+		var data []byte
+		if data, err = httpGet(p.Context(), item.Link); err == nil {
+			p.ReplyDocument(&models.InputFileUpload{Filename: item.Title + ".torrent", Data: bytes.NewReader(data)})
+		}
+	default:
+		if category, ok := downloadCategory(action); ok {
+			if _, err = p.backends.download(p.Context(), &p.Paginator, p.query, item, instance, urlOrMagnet, category, nil); err == nil {
+				item.InTorrents = true
+			}
 		}
 	}
 	if err != nil {
 		utils.LogError(err)
+		p.ReplyMessage(resilience.Message(err))
 		return false
 	}
 	return true
@@ -195,45 +244,192 @@ func (p *FindPaginator) Execute(i int, action string) (unselect bool) {
 
 func (p *FindPaginator) Reload() error {
 
-	result, err := jackett.Query(p.query, common.Settings.Jackett.Indexers)
+	result, err := p.backends.Jackett.Query(p.Context(), p.query, common.Current().Jackett.Indexers)
 	if err != nil {
 		utils.LogError(err)
 		return err
 	}
 
-	trList, err := transmission.List()
-	if err != nil {
-		utils.LogError(err)
-	} else {
-		for _, el := range *trList {
-			p.transmissionHashes[*el.HashString] = true
+	var trList []client.Torrent
+	for name, c := range p.backends.Clients.Iter() {
+		if torrents, err := c.List(p.Context()); err != nil {
+			utils.LogError(errors.Wrap(err, name))
+		} else {
+			trList = append(trList, torrents...)
 		}
 	}
+	tsList, tsErr := p.backends.Torrserver.List(p.Context())
+	if tsErr != nil {
+		utils.LogError(tsErr)
+	}
+
+	p.Locked(func() {
+		for _, el := range trList {
+			p.transmissionHashes[el.Hash] = true
+		}
+		if tsErr == nil {
+			for _, el := range *tsList {
+				p.torrserverHashes[el.Hash] = true
+			}
+		}
+		p.Alloc(len(*result))
+		for i := range *result {
+			hash := (*result)[i].InfoHash
+			p.Append(&ListItem{
+				(*result)[i],
+				p.transmissionHashes[hash],
+				p.torrserverHashes[hash],
+			})
+		}
+	})
+	return nil
+
+}
+
+// -------------------------------------------------------------------------
+// categoryAction is "download" for the first category and "download:<name>" for the others
+func categoryAction(i int, category common.Category) string {
+	if i == 0 {
+		return "download"
+	}
+	return "download:" + category.Name
+}
+
+// downloadActions are the buttons adding a torrent to the download client, one per category,
+// with more than one instance the target is chosen by "@name" suffix
+func (backends Backends) downloadActions() (result []string) {
+	for i, category := range common.Current().Categories() {
+		action := categoryAction(i, category)
+		if backends.Clients.Len() > 1 {
+			for _, name := range backends.Clients.IterKeys() {
+				result = append(result, action+"@"+name)
+			}
+		} else {
+			result = append(result, action)
+		}
+	}
+	return result
+}
+
+// downloadCategory is the category of the download action
+func downloadCategory(action string) (common.Category, bool) {
+	for i, category := range common.Current().Categories() {
+		if categoryAction(i, category) == action {
+			return category, true
+		}
+	}
+	return common.Category{}, false
+}
 
-	tsList, err := torrserver.List()
+// download adds the torrent to the named instance, to the default one if the name is empty,
+// the unwanted files are skipped before the torrent starts, the user is notified on completion
+func (backends Backends) download(ctx context.Context, p *paginator.Paginator, query string, item *ListItem, instance string, urlOrMagnet string, category common.Category, unwanted []int) (string, error) {
+	name, c := backends.Clients.Default()
+	if instance != "" {
+		var ok bool
+		if c, ok = backends.Clients.Get(instance); !ok {
+			return "", errors.New("download client " + instance + " is not configured")
+		}
+		name = instance
+	}
+	if c == nil {
+		return "", errors.New("no download client configured")
+	}
+	options := client.AddOptions{
+		DownloadDir:    category.Path,
+		Labels:         category.Labels,
+		BandwidthGroup: category.BandwidthGroup,
+		Paused:         len(unwanted) > 0,
+	}
+	if len(category.Labels) > 0 {
+		options.Category = category.Labels[0]
+	}
+	torrent, err := c.Add(ctx, urlOrMagnet, options)
 	if err != nil {
-		utils.LogError(err)
-	} else {
-		for _, el := range *tsList {
-			p.torrserverHashes[el.Hash] = true
+		return "", err
+	}
+	hash := strings.ToLower(torrent.Hash)
+	if hash == "" {
+		hash = strings.ToLower(item.InfoHash)
+	}
+	if len(unwanted) > 0 {
+		if err = pickFiles(ctx, c, hash, unwanted); err != nil { // it's in the client anyway, the user may fix it in /downloads
+			utils.LogError(errors.Wrap(err, name))
+			p.ReplyMessage("⚠️ " + item.Title + " is added to " + name + " paused with all the files: " + resilience.Message(err))
 		}
 	}
+	recordOwner(p, query, hash, item, name)
+	watcher.Track(name, hash, item.Title, p.UserID())
+	return name, nil
+}
 
-	p.Alloc(len(*result))
-	for i := range *result {
-		hash := (*result)[i].InfoHash
-		p.Append(&ListItem{
-			(*result)[i],
-			p.transmissionHashes[hash],
-			p.torrserverHashes[hash],
-		})
+// addWait limits waiting for the added torrent to be listed, qBittorrent adds torrents asynchronously
+var addWait = 10 * time.Second
+
+// pickFiles skips the unwanted files of the paused torrent and starts it, once the client lists it
+func pickFiles(ctx context.Context, c client.DownloadClient, hash string, unwanted []int) error {
+	waitCtx, cancel := context.WithTimeout(ctx, addWait)
+	defer cancel()
+	for {
+		torrents, err := c.List(waitCtx)
+		if err == nil && slices.ContainsFunc(torrents, func(t client.Torrent) bool { return strings.EqualFold(t.Hash, hash) }) {
+			break
+		}
+		select {
+		case <-time.After(addWait / 20):
+		case <-waitCtx.Done():
+			if err == nil {
+				err = errors.New("torrent " + hash + " is not listed")
+			}
+			return err
+		}
 	}
-	return nil
+	if err := c.SetWanted(ctx, hash, unwanted, false); err != nil {
+		return err
+	}
+	return c.Start(ctx, hash)
+}
 
+func recordOwner(p *paginator.Paginator, query string, hash string, item *ListItem, target string) {
+	owners.Record(hash, owners.Owner{
+		UserID:   p.UserID(),
+		UserName: p.UserName(),
+		ChatID:   p.ChatID(),
+		Query:    query,
+		Indexer:  item.TrackerId,
+		Target:   target,
+	})
 }
 
-// -------------------------------------------------------------------------
-func getPosterLinkFromPage(pageUrl string, tracker string) string {
+// PAGE_TIMEOUT limits the downloads of tracker pages and .torrent files
+const PAGE_TIMEOUT = 10 * time.Second
+
+// httpGet downloads the page, decoded to utf-8 if it's html or text
+func httpGet(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, PAGE_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(url + ": " + res.Status)
+	}
+	body := io.Reader(res.Body)
+	if contentType := res.Header.Get("Content-Type"); strings.HasPrefix(contentType, "text/") {
+		if body, err = charset.NewReader(res.Body, contentType); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(body)
+}
+
+func getPosterLinkFromPage(ctx context.Context, pageUrl string, tracker string) string {
 
 	var findKey = func(attr []html.Attribute, key string) string {
 		for i := range attr {
@@ -249,7 +445,12 @@ func getPosterLinkFromPage(pageUrl string, tracker string) string {
 	// 	return ""
 	// }
 
-	doc, err := htmlquery.LoadURL(pageUrl)
+	page, err := httpGet(ctx, pageUrl)
+	if err != nil {
+		utils.LogError(err)
+		return ""
+	}
+	doc, err := htmlquery.Parse(bytes.NewReader(page))
 	if err != nil {
 		utils.LogError(err)
 		return ""
@@ -282,18 +483,28 @@ func getPosterLinkFromPage(pageUrl string, tracker string) string {
 	return ""
 }
 
-func Handler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	var p = NewPaginator(ctx, b, update)
-	p.SetupSorting([]paginator.Sorting{
-		{Attribute: "Size", Alias: "size", Order: 1},
-		{Attribute: "Seeders", Alias: "seeds", Order: 1},
-		{Attribute: "Peers", Alias: "peers", Order: 0},
-		{Attribute: "Link", Alias: "file", Order: 0},
-	})
-	p.SetupFiltering([]string{"TrackerId"})
-	if err := p.Reload(); err != nil {
-		p.ReplyMessage(err.Error())
-	} else {
-		p.Show()
+// NewRestorer repeats the search after restart, see paginator.RegisterRestorer
+func NewRestorer(backends Backends) paginator.Restorer {
+	return func(ctx context.Context, b *bot.Bot, state *paginator.State) (*paginator.Paginator, error) {
+		p := NewPaginator(ctx, b, nil, backends, state.Query)
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+		p.Restore(state)
+		return &p.Paginator, nil
+	}
+}
+
+func NewHandler(backends Backends) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		if update.Message == nil { // default handler also receives edits, inline queries, etc.
+			return
+		}
+		var p = NewPaginator(ctx, b, update, backends, update.Message.Text)
+		if err := p.Reload(); err != nil {
+			p.ReplyMessage(resilience.Message(err))
+		} else {
+			p.Show()
+		}
 	}
 }
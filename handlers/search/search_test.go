@@ -0,0 +1,392 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot"
+
+	"torrentino/api/client"
+	"torrentino/api/jackett"
+	apitorrserver "torrentino/api/torrserver"
+	"torrentino/common"
+	"torrentino/common/owners"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+	"torrentino/fakes/telegram"
+	"torrentino/fakes/torrentfile"
+	"torrentino/fakes/torrserver"
+	"torrentino/fakes/transmission"
+)
+
+func newHarness(t *testing.T, b *backends.Backends) *telegram.Harness {
+	common.Settings.UsersList = []int64{1}
+	return telegram.NewHarness(t, 1, 1, bot.WithDefaultHandler(NewHandler(Backends{b.JackettClient, b.TorrserverClient, b.Clients})))
+}
+
+// offline are the clients of the services which are not running
+func offline(t *testing.T) *backends.Backends {
+	backends.FastRetries(t)
+	closed := common.HostPort{Host: "127.0.0.1", Port: 1} // nothing listens there, unlike the default ports
+	jkt, err := jackett.NewClient(common.Jackett{HostPort: closed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &backends.Backends{JackettClient: jkt, TorrserverClient: apitorrserver.NewClient(closed), Clients: client.NewClients()}
+}
+
+func TestJackettDown(t *testing.T) {
+	h := newHarness(t, offline(t))
+	h.Send("ubuntu")
+	m := h.Last()
+	if m.Text != "Jackett unavailable, retrying in 30 s" {
+		t.Errorf("expected Jackett error, got %q", m.Text)
+	}
+	if len(m.Keyboard) != 0 {
+		t.Errorf("error reply must not have keyboard, got %v", m.Buttons())
+	}
+}
+
+func TestNonMessageUpdateIgnored(t *testing.T) {
+	h := newHarness(t, offline(t))
+	h.PressData(1, "unknown")
+	if len(h.Messages(h.ChatID)) != 0 {
+		t.Error("callback without registered handler must not start a search")
+	}
+}
+
+func TestSearchAndDownload(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	b.Jackett.AddResults(
+		jackett.Result{Title: "Ubuntu 24.04", TrackerId: "rutor", Size: 6 << 30, Seeders: 10, MagnetUri: "magnet:?xt=urn:btih:aaa&dn=ubuntu"},
+		jackett.Result{Title: "Ubuntu 22.04", TrackerId: "rutor", Size: 4 << 30, Seeders: 5, MagnetUri: "magnet:?xt=urn:btih:bbb&dn=ubuntu", InfoHash: "bbb"},
+	)
+	b.Torrserver.AddTorrent(torrserver.Torrent{Title: "Ubuntu 22.04", Hash: "bbb"})
+
+	h.Send("ubuntu")
+	m := h.Last()
+	if !strings.Contains(m.Text, "results: 1-2 of 2") || !strings.Contains(m.Text, "Ubuntu 24.04 [6.00 GB] [rutor] [10s/0p] 🧲") {
+		t.Fatalf("unexpected list %q", m.Text)
+	}
+	if !strings.Contains(m.Text, "Ubuntu 22.04 [4.00 GB] [rutor] [5s/0p] 🧲 🎦") {
+		t.Errorf("item in torrserver is not marked in %q", m.Text)
+	}
+
+	h.Press(m, "1")
+	h.Press(h.Last(), "download:series")
+	torrents := b.Transmission.Torrents()
+	if len(torrents) != 1 || torrents[0].DownloadDir != common.Settings.Path.Series || torrents[0].HashString != "aaa" {
+		t.Errorf("unexpected torrents %v", torrents)
+	}
+	if m = h.Last(); !strings.Contains(m.Text, "Ubuntu 24.04 [6.00 GB] [rutor] [10s/0p] 🧲 📥") {
+		t.Errorf("downloaded item is not marked in %q", m.Text)
+	}
+	if owner, ok := owners.Get("aaa"); !ok || owner.UserID != h.UserID || owner.ChatID != h.ChatID || owner.Query != "ubuntu" ||
+		owner.Indexer != "rutor" || owner.Target != "transmission" {
+		t.Errorf("unexpected owner %v", owner)
+	}
+}
+
+func TestDownloadToInstance(t *testing.T) {
+	b := backends.Start(t)
+	seedbox := b.AddTransmission(t, "seedbox")
+	h := newHarness(t, b)
+	b.Jackett.AddResults(jackett.Result{Title: "Ubuntu 24.04", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:aaa&dn=ubuntu"})
+
+	h.Send("ubuntu")
+	h.Press(h.Last(), "1")
+	m := h.Last()
+	if _, ok := m.Button("download"); ok {
+		t.Errorf("instance must be chosen explicitly, got %v", m.Buttons())
+	}
+	h.Press(m, "download:movie@seedbox")
+	if torrents := seedbox.Torrents(); len(torrents) != 1 || torrents[0].DownloadDir != common.Settings.Path.Movie {
+		t.Errorf("unexpected seedbox torrents %v", torrents)
+	}
+	if len(b.Transmission.Torrents()) != 0 {
+		t.Error("torrent is added to the wrong instance")
+	}
+}
+
+func TestPickFiles(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	torrent := torrentfile.Torrent{Name: "series", Files: []torrentfile.File{{Path: "s01e01.mkv", Length: 100}, {Path: "s01e02.mkv", Length: 200}}}
+	b.Jackett.AddResults(jackett.Result{Title: "Series S01", TrackerId: "rutor", Link: b.Jackett.AddFile("series.torrent", torrent.Encode())})
+
+	h.Send("series")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "pick files")
+	m := h.Last()
+	if len(h.Messages(h.ChatID)) != 2 {
+		t.Fatal("files must be shown in a separate message")
+	}
+	if !strings.Contains(m.Text, "☑️ s01e01.mkv [100 B]") || !strings.Contains(m.Text, "2 of 2 files selected, 300 B") {
+		t.Fatalf("unexpected files view %q", m.Text)
+	}
+
+	h.Press(m, "2")
+	h.Press(h.Last(), "skip")
+	if m = h.Last(); !strings.Contains(m.Text, "⬜ s01e02.mkv [200 B]") || !strings.Contains(m.Text, "1 of 2 files selected, 100 B") {
+		t.Fatalf("unchecked file is not rendered in %q", m.Text)
+	}
+	h.Press(m, "download:series")
+	torrents := b.Transmission.Torrents()
+	if len(torrents) != 1 || torrents[0].HashString != torrent.InfoHash() || torrents[0].DownloadDir != common.Settings.Path.Series {
+		t.Fatalf("unexpected torrents %v", torrents)
+	}
+	if stats := torrents[0].FileStats; !stats[0].Wanted || stats[1].Wanted || torrents[0].Status != transmission.DOWNLOADING {
+		t.Errorf("expected started torrent with the second file skipped, got %v", torrents[0])
+	}
+	m = h.Last()
+	if _, ok := m.Button("download:series"); !strings.Contains(m.Text, "added to transmission") || ok {
+		t.Errorf("unexpected view after download %q %v", m.Text, m.Buttons())
+	}
+	if _, ok := owners.Get(torrent.InfoHash()); !ok {
+		t.Error("owner is not recorded")
+	}
+}
+
+// lagging hides the added torrents from the first lists, as qBittorrent does, and may fail to skip files
+type lagging struct {
+	client.DownloadClient
+	hidden    int
+	setWanted error
+}
+
+func (c *lagging) List(ctx context.Context) ([]client.Torrent, error) {
+	if c.hidden > 0 {
+		c.hidden--
+		return nil, nil
+	}
+	return c.DownloadClient.List(ctx)
+}
+
+func (c *lagging) SetWanted(ctx context.Context, hash string, files []int, wanted bool) error {
+	if c.setWanted != nil {
+		return c.setWanted
+	}
+	if c.hidden > 0 {
+		return errors.New("404 Not Found")
+	}
+	return c.DownloadClient.SetWanted(ctx, hash, files, wanted)
+}
+
+// pickSecondFile downloads the first file of two-file torrent
+func pickSecondFile(t *testing.T, b *backends.Backends, h *telegram.Harness) {
+	torrent := torrentfile.Torrent{Name: "series", Files: []torrentfile.File{{Path: "s01e01.mkv", Length: 100}, {Path: "s01e02.mkv", Length: 200}}}
+	b.Jackett.AddResults(jackett.Result{Title: "Series S01", TrackerId: "rutor", Link: b.Jackett.AddFile("series.torrent", torrent.Encode())})
+	h.Send("series")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "pick files")
+	h.Press(h.Last(), "2")
+	h.Press(h.Last(), "skip")
+	h.Press(h.Last(), "download")
+}
+
+func TestPickFilesAsyncAdd(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	c, _ := b.Clients.Get("transmission")
+	b.Clients.Set("transmission", &lagging{DownloadClient: c, hidden: 2})
+	wait := addWait
+	addWait = time.Second
+	t.Cleanup(func() { addWait = wait })
+
+	pickSecondFile(t, b, h)
+	if torrents := b.Transmission.Torrents(); len(torrents) != 1 || torrents[0].FileStats[1].Wanted || torrents[0].Status != transmission.DOWNLOADING {
+		t.Errorf("expected started torrent with the second file skipped, got %v", torrents)
+	}
+}
+
+func TestPickFilesFailed(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	c, _ := b.Clients.Get("transmission")
+	b.Clients.Set("transmission", &lagging{DownloadClient: c, setWanted: errors.New("torrent-set: invalid argument")})
+
+	pickSecondFile(t, b, h)
+	if torrents := b.Transmission.Torrents(); len(torrents) != 1 || torrents[0].Status != transmission.STOPPED {
+		t.Errorf("expected paused torrent, got %v", torrents)
+	}
+	messages := h.Messages(h.ChatID)
+	if m := messages[len(messages)-1]; !strings.Contains(m.Text, "Series S01 is added to transmission paused with all the files") ||
+		!strings.Contains(m.Text, "invalid argument") {
+		t.Errorf("unexpected warning %q", m.Text)
+	}
+}
+
+// waitFor returns the first message containing the text, the background jobs report that way
+func waitFor(t *testing.T, h *telegram.Harness, text string) telegram.Message {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, m := range h.Messages(h.ChatID) {
+			if strings.Contains(m.Text, text) {
+				return m
+			}
+		}
+	}
+	t.Fatalf("no message with %q in %v", text, h.Messages(h.ChatID))
+	return telegram.Message{}
+}
+
+func TestDownloadFailed(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	b.Jackett.AddResults(jackett.Result{Title: "Ubuntu 24.04", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:aaa&dn=ubuntu"})
+
+	h.Send("ubuntu")
+	h.Press(h.Last(), "1")
+	b.Transmission.Inject(fault.Fault{Status: http.StatusInternalServerError, Times: 1}) // adding is not retried
+	h.Press(h.Last(), "download:series")
+	if m := h.Last(); !strings.Contains(m.Text, "Transmission") || m.Keyboard != nil {
+		t.Errorf("expected the error reply, got %q", m.Text)
+	}
+	if len(b.Transmission.Torrents()) != 0 {
+		t.Error("the torrent must not be added")
+	}
+}
+
+func TestPreviewTorrent(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	torrent := torrentfile.Torrent{
+		Name:        "series",
+		Files:       []torrentfile.File{{Path: "s01/e01.mkv", Length: 100}, {Path: "s01/e02.mkv", Length: 200}, {Path: "info.nfo", Length: 1}},
+		PieceLength: 4 << 20, Private: true, Trackers: []string{"http://tracker/announce"},
+	}
+	b.Jackett.AddResults(jackett.Result{Title: "Series S01", TrackerId: "rutor", Link: b.Jackett.AddFile("series.torrent", torrent.Encode())})
+
+	h.Send("series")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "files")
+	m := waitFor(t, h, "<b>series</b>")
+	for _, expected := range []string{"info.nfo [1 B]\n📁 s01\n  e01.mkv [100 B]\n  e02.mkv [200 B]", "files: 3, total: 301 B",
+		"piece size: 4.00 MB", "private: yes", "http://tracker/announce"} {
+		if !strings.Contains(m.Text, expected) {
+			t.Errorf("%q is missing in %q", expected, m.Text)
+		}
+	}
+}
+
+func TestPreviewMagnet(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	b.Jackett.AddResults(jackett.Result{Title: "Ubuntu", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:aaa&dn=ubuntu&tr=udp://tracker:80"})
+	b.Torrserver.Metadata("aaa", "ubuntu", torrserver.File{Path: "ubuntu/ubuntu.iso", Length: 6 << 30})
+
+	h.Send("ubuntu")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "files")
+	if m := waitFor(t, h, "ubuntu.iso [6.00 GB]"); !strings.Contains(m.Text, "metadata from: TorrServer") || !strings.Contains(m.Text, "udp://tracker:80") {
+		t.Errorf("unexpected preview %q", m.Text)
+	}
+	if len(b.Torrserver.Torrents()) != 0 {
+		t.Error("preloaded torrent must be removed from TorrServer")
+	}
+}
+
+func TestPreviewMagnetKnown(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	// the magnet has the hash in base32, TorrServer knows the torrent by hex
+	hash := "0123456789abcdef0123456789abcdef01234567"
+	b.Jackett.AddResults(jackett.Result{Title: "Ubuntu", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH&dn=ubuntu"})
+	b.Torrserver.AddTorrent(torrserver.Torrent{Title: "Ubuntu", Name: "ubuntu", Hash: hash, FileStats: []torrserver.File{{Path: "ubuntu/ubuntu.iso", Length: 6 << 30}}})
+
+	h.Send("ubuntu")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "files")
+	waitFor(t, h, "ubuntu.iso [6.00 GB]")
+	if torrents := b.Torrserver.Torrents(); len(torrents) != 1 || torrents[0].Hash != hash {
+		t.Errorf("the torrent watched before must stay in TorrServer, got %v", torrents)
+	}
+}
+
+func TestPreviewMagnetNoTorrserver(t *testing.T) {
+	b := backends.Start(t)
+	backends.FastRetries(t)
+	h := newHarness(t, b)
+	b.Jackett.AddResults(jackett.Result{Title: "Ubuntu", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:aaa&dn=ubuntu"})
+	b.Transmission.Metadata("aaa", "ubuntu", transmission.File{Name: "ubuntu/ubuntu.iso", Length: 6 << 30})
+	b.Torrserver.Close()
+
+	h.Send("ubuntu")
+	h.Press(h.Last(), "1")
+	h.Press(h.Last(), "files")
+	waitFor(t, h, "TorrServer")
+	if torrents := b.Transmission.Torrents(); len(torrents) != 0 {
+		t.Errorf("the download client must not be touched, got %v", torrents)
+	}
+}
+
+func TestPreviewInBackground(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	timeout, poll := metadataTimeout, metadataPoll
+	metadataTimeout, metadataPoll = 2*time.Second, 10*time.Millisecond
+	t.Cleanup(func() { metadataTimeout, metadataPoll = timeout, poll })
+	b.Jackett.AddResults(
+		jackett.Result{Title: "Ubuntu", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:aaa&dn=ubuntu"}, // no peers have it
+		jackett.Result{Title: "Ubuntu server", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:bbb&dn=server"},
+	)
+
+	h.Send("ubuntu")
+	h.Press(h.Last(), "1")
+	start := time.Now()
+	h.Press(h.Last(), "files")
+	h.Press(h.Last(), "2")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the list must keep working while the metadata is fetched, blocked for %v", elapsed)
+	}
+	waitFor(t, h, "metadata timeout")
+	if len(b.Torrserver.Torrents()) != 0 {
+		t.Error("preloaded torrent must be removed from TorrServer")
+	}
+}
+
+func TestPreviewLength(t *testing.T) {
+	pv := &Preview{Name: "series", Source: "TorrServer"}
+	for i := range MAX_PREVIEW_FILES {
+		pv.Files = append(pv.Files, PreviewFile{fmt.Sprintf("%02d %s/episode.mkv", i, strings.Repeat("season & extras ", 5)), 1 << 30})
+	}
+	for range 20 {
+		pv.Trackers = append(pv.Trackers, "udp://"+strings.Repeat("tracker", 5)+".example.org:80/announce")
+	}
+	text := pv.String()
+	if textLength(text) > MAX_MESSAGE_LENGTH || !strings.Contains(text, "more files") || !strings.Contains(text, "files: 50, total: 50.00 GB") {
+		t.Errorf("the preview must be cut to the message limit, got %d characters: %q", textLength(text), text)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	b := backends.Start(t)
+	common.Settings.CategoryList = []common.Category{
+		{Name: "tv", Path: t.TempDir(), Icon: "📺"},
+		{Name: "4k", Path: t.TempDir(), Icon: "🎞", Labels: []string{"movie", "uhd"}, BandwidthGroup: "night"},
+	}
+	h := newHarness(t, b)
+	b.Jackett.AddResults(jackett.Result{Title: "Movie", TrackerId: "rutor", MagnetUri: "magnet:?xt=urn:btih:aaa&dn=movie"})
+
+	h.Send("movie")
+	h.Press(h.Last(), "1")
+	m := h.Last()
+	if _, ok := m.Button("download:series"); ok {
+		t.Errorf("legacy paths must be replaced by the categories, got %v", m.Buttons())
+	}
+	if _, ok := m.Button("download"); !ok {
+		t.Errorf("the first category must be the plain download, got %v", m.Buttons())
+	}
+	h.Press(m, "download:4k")
+	torrents := b.Transmission.Torrents()
+	if len(torrents) != 1 || torrents[0].DownloadDir != common.Settings.CategoryList[1].Path ||
+		len(torrents[0].Labels) != 2 || torrents[0].Group != "night" {
+		t.Errorf("unexpected torrents %v", torrents)
+	}
+}
@@ -0,0 +1,260 @@
+// Package status is the /status dashboard: reachability and latency of the backends
+// and free space of the download paths
+package status
+
+import (
+	"context"
+	"html"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+
+	"torrentino/api/client"
+	"torrentino/api/jackett"
+	"torrentino/api/resilience"
+	"torrentino/api/torrserver"
+	"torrentino/common"
+	"torrentino/common/paginator"
+	"torrentino/common/utils"
+)
+
+// CHECK_TIMEOUT limits all the checks together, a backend which doesn't answer by then is shown failed
+const CHECK_TIMEOUT = 15 * time.Second
+
+// Check is the result of one probe, Latency is 0 for the local ones
+type Check struct {
+	Name    string
+	Latency time.Duration
+	Details string
+	Err     error
+}
+
+type StatusPaginator struct {
+	paginator.Paginator
+	jackett    *jackett.Client
+	torrserver *torrserver.Client
+	clients    *client.Clients
+	checked    time.Time
+}
+
+// ----------------------------------------
+func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update, jkt *jackett.Client, ts *torrserver.Client, clients *client.Clients) *StatusPaginator {
+	var p StatusPaginator
+	p = StatusPaginator{
+		*paginator.New(ctx, b, update, "status", 10, &p, &p, &p),
+		jkt,
+		ts,
+		clients,
+		time.Time{},
+	}
+	return &p
+}
+
+func (p *StatusPaginator) Item(i int) *Check {
+	return p.Paginator.Item(i).(*Check)
+}
+
+// paginator.Identifier
+func (p *StatusPaginator) ID(i int) string {
+	return p.Item(i).Name
+}
+
+// method overload
+func (p *StatusPaginator) Header() string {
+	return "<b>status at " + p.checked.Format(time.TimeOnly) + "</b>"
+}
+
+// method overload
+func (p *StatusPaginator) Footer() string {
+	failed := 0
+	for i := range p.Len() {
+		if p.Item(i).Err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return "all is well"
+	}
+	return strconv.Itoa(failed) + " of " + strconv.Itoa(p.Len()) + " failed"
+}
+
+// method overload
+func (p *StatusPaginator) Line(i int) string {
+	item := p.Item(i)
+	result := "✅ <b>" + html.EscapeString(item.Name) + "</b>"
+	if item.Err != nil {
+		result = "❌ <b>" + html.EscapeString(item.Name) + "</b>"
+	}
+	if item.Latency > 0 {
+		result += " [" + strconv.FormatInt(item.Latency.Milliseconds(), 10) + " ms]"
+	}
+	if item.Err != nil {
+		return result + "\n" + html.EscapeString(resilience.Message(item.Err))
+	}
+	if item.Details != "" {
+		result += "\n" + html.EscapeString(item.Details)
+	}
+	return result
+}
+
+// method overload
+func (p *StatusPaginator) Stringify(i int, attribute string) string {
+	return ""
+}
+
+// method overload
+func (p *StatusPaginator) Compare(i int, j int, attribute string) bool {
+	return false
+}
+
+// paginator.Refresher
+func (p *StatusPaginator) Refresh() {
+	p.Reload()
+}
+
+// Reload runs the checks, the failures are the part of the result
+func (p *StatusPaginator) Reload() {
+	checks := p.check()
+	p.Locked(func() {
+		p.checked = time.Now()
+		p.Alloc(len(checks))
+		for _, c := range checks {
+			p.Append(c)
+		}
+	})
+}
+
+// check runs the groups of checks at once, the order of the results is fixed
+func (p *StatusPaginator) check() []*Check {
+	ctx, cancel := context.WithTimeout(p.Context(), CHECK_TIMEOUT)
+	defer cancel()
+
+	groups := []func(ctx context.Context) []*Check{
+		p.checkJackett,
+		p.checkClients,
+		p.checkTorrserver,
+		checkDisks,
+	}
+	results := make([][]*Check, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = group(ctx)
+		}()
+	}
+	wg.Wait()
+	return slices.Concat(results...)
+}
+
+// measure times the probe
+func measure(name string, probe func() (details string, err error)) *Check {
+	start := time.Now()
+	details, err := probe()
+	return &Check{Name: name, Latency: time.Since(start), Details: details, Err: err}
+}
+
+// checkJackett lists the configured indexers and probes the ones used for search
+func (p *StatusPaginator) checkJackett(ctx context.Context) []*Check {
+	var indexers []jackett.Indexer
+	result := []*Check{measure("Jackett", func() (string, error) {
+		all, err := p.jackett.GetValidIndexers(ctx)
+		if err != nil {
+			return "", err
+		}
+		wanted := common.Current().Jackett.Indexers
+		for _, indexer := range *all {
+			if len(wanted) == 0 || slices.Contains(wanted, indexer.ID) {
+				indexers = append(indexers, indexer)
+			}
+		}
+		return strconv.Itoa(len(indexers)) + " indexers", nil
+	})}
+
+	probes := make([]*Check, len(indexers))
+	var wg sync.WaitGroup
+	for i, indexer := range indexers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[i] = measure("🔎 "+indexer.Name, func() (string, error) {
+				return "", p.jackett.Probe(ctx, indexer.ID)
+			})
+		}()
+	}
+	wg.Wait()
+	return append(result, probes...)
+}
+
+// checkClients asks the download clients for stats, the ones which can't tell them are pinged with List
+func (p *StatusPaginator) checkClients(ctx context.Context) (result []*Check) {
+	for name, c := range p.clients.Iter() {
+		result = append(result, measure("⬇️ "+name, func() (string, error) {
+			if reporter, ok := c.(client.Reporter); ok {
+				stats, err := reporter.Stats(ctx)
+				if err != nil {
+					return "", err
+				}
+				return "version " + stats.Version + ", " +
+					strconv.FormatInt(stats.Torrents, 10) + " torrents, " +
+					strconv.FormatInt(stats.Active, 10) + " active\n" +
+					"↓ " + utils.FormatFileSize(uint64(stats.DownloadSpeed)) + "/s " +
+					"↑ " + utils.FormatFileSize(uint64(stats.UploadSpeed)) + "/s", nil
+			}
+			torrents, err := c.List(ctx)
+			return strconv.Itoa(len(torrents)) + " torrents", err
+		}))
+	}
+	return result
+}
+
+func (p *StatusPaginator) checkTorrserver(ctx context.Context) []*Check {
+	return []*Check{measure("TorrServer", func() (string, error) {
+		version, err := p.torrserver.Echo(ctx)
+		return "version " + version, err
+	})}
+}
+
+// checkDisks reports the space of every category path, the paths of one volume are reported separately
+func checkDisks(ctx context.Context) (result []*Check) {
+	var paths []string
+	for _, category := range common.Current().Categories() {
+		if slices.Contains(paths, category.Path) {
+			continue
+		}
+		paths = append(paths, category.Path)
+		check := &Check{Name: category.Icon + " " + category.Path}
+		used, free, err := utils.DiskUsage(category.Path)
+		if err != nil {
+			check.Err = err
+		} else {
+			check.Details = utils.FormatFileSize(used) + " used / " + utils.FormatFileSize(free) + " free"
+		}
+		result = append(result, check)
+	}
+	return result
+}
+
+// -------------------------------------------------------------------------
+// NewRestorer rehydrates the dashboard after restart with fresh checks, see paginator.RegisterRestorer
+func NewRestorer(jkt *jackett.Client, ts *torrserver.Client, clients *client.Clients) paginator.Restorer {
+	return func(ctx context.Context, b *bot.Bot, state *paginator.State) (*paginator.Paginator, error) {
+		p := NewPaginator(ctx, b, nil, jkt, ts, clients)
+		p.Reload()
+		p.Restore(state)
+		return &p.Paginator, nil
+	}
+}
+
+func NewHandler(jkt *jackett.Client, ts *torrserver.Client, clients *client.Clients) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		p := NewPaginator(ctx, b, update, jkt, ts, clients)
+		p.Reload()
+		p.Show()
+	}
+}
@@ -0,0 +1,92 @@
+package status
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot"
+
+	"torrentino/api/jackett"
+	"torrentino/common"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+	"torrentino/fakes/telegram"
+	"torrentino/fakes/transmission"
+)
+
+func newHarness(t *testing.T, b *backends.Backends) *telegram.Harness {
+	common.Settings.UsersList = []int64{1}
+	return telegram.NewHarness(t, 1, 1, bot.WithMessageTextHandler("/status", bot.MatchTypeExact,
+		NewHandler(b.JackettClient, b.TorrserverClient, b.Clients)))
+}
+
+func TestStatus(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	b.Jackett.AddIndexers(
+		jackett.Indexer{ID: "rutracker", Name: "RuTracker", Configured: true},
+		jackett.Indexer{ID: "broken", Name: "Broken", Configured: true, Error: "captcha required"},
+		jackett.Indexer{ID: "idle", Name: "Idle"},
+	)
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.SEEDING})
+	b.Transmission.AddTorrent(transmission.Torrent{Name: "debian.iso", Status: transmission.STOPPED})
+
+	h.Send("/status")
+	m := h.Last()
+	for _, expected := range []string{
+		"✅ <b>Jackett</b>", "2 indexers",
+		"✅ <b>🔎 RuTracker</b>", "❌ <b>🔎 Broken</b>", "captcha required",
+		"✅ <b>⬇️ transmission</b>", "version " + transmission.VERSION + ", 2 torrents, 1 active",
+		"✅ <b>TorrServer</b>", "version MatriX.fake",
+		"📥 " + common.Settings.Path.Default, "🎬 " + common.Settings.Path.Movie, " free",
+		"1 of 8 failed",
+	} {
+		if !strings.Contains(m.Text, expected) {
+			t.Errorf("%q is not found in %q", expected, m.Text)
+		}
+	}
+	if strings.Contains(m.Text, "Idle") {
+		t.Errorf("not configured indexer is checked in %q", m.Text)
+	}
+}
+
+func TestIndexersSetting(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	b.Jackett.AddIndexers(
+		jackett.Indexer{ID: "rutracker", Name: "RuTracker", Configured: true},
+		jackett.Indexer{ID: "kinozal", Name: "Kinozal", Configured: true},
+	)
+	common.Settings.Jackett.Indexers = []string{"kinozal"}
+	t.Cleanup(func() { common.Settings.Jackett.Indexers = nil })
+
+	h.Send("/status")
+	if m := h.Last(); strings.Contains(m.Text, "RuTracker") || !strings.Contains(m.Text, "🔎 Kinozal") {
+		t.Errorf("only the indexers in use must be checked, got %q", m.Text)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b)
+	h.Send("/status")
+	m := h.Last()
+	if !strings.Contains(m.Text, "all is well") {
+		t.Fatalf("unexpected status %q", m.Text)
+	}
+
+	b.Torrserver.Inject(fault.Fault{Status: http.StatusBadGateway})
+	if _, ok := m.Button("🔄"); !ok {
+		t.Fatalf("refresh button must be shown without selection, got %v", m.Buttons())
+	}
+	h.Press(m, "🔄")
+	refreshed := h.Last()
+	if refreshed.ID != m.ID {
+		t.Errorf("refresh must edit the message in place, got a new one %d", refreshed.ID)
+	}
+	if !strings.Contains(refreshed.Text, "❌ <b>TorrServer</b>") ||
+		!strings.Contains(refreshed.Text, "TorrServer unavailable, retrying in 30 s") {
+		t.Errorf("TorrServer failure is not shown in %q", refreshed.Text)
+	}
+}
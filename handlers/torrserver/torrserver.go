@@ -6,6 +6,7 @@ import (
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
 
+	"torrentino/api/resilience"
 	"torrentino/api/torrserver"
 	"torrentino/common/paginator"
 	"torrentino/common/utils"
@@ -13,14 +14,19 @@ import (
 
 type TorrserverPaginator struct {
 	paginator.Paginator
+	torrserver *torrserver.Client
 }
 
 // ----------------------------------------
-func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update) *TorrserverPaginator {
+func NewPaginator(ctx context.Context, b *bot.Bot, update *models.Update, ts *torrserver.Client) *TorrserverPaginator {
 	var p TorrserverPaginator
 	p = TorrserverPaginator{
 		*paginator.New(ctx, b, update, "torrserver", 4, &p, &p, &p),
+		ts,
 	}
+	p.SetupSorting([]paginator.Sorting{
+		{Attribute: "Size", Alias: "size", Order: 1},
+	})
 	return &p
 }
 
@@ -28,6 +34,11 @@ func (p *TorrserverPaginator) Item(i int) *torrserver.TSListItem {
 	return p.Paginator.Item(i).(*torrserver.TSListItem)
 }
 
+// paginator.Identifier
+func (p *TorrserverPaginator) ID(i int) string {
+	return p.Item(i).Hash
+}
+
 // method overload
 func (p *TorrserverPaginator) Line(i int) string {
 	item := p.Item(i)
@@ -44,10 +55,11 @@ func (p *TorrserverPaginator) Actions(i int) []string {
 func (p *TorrserverPaginator) Execute(i int, action string) (unselect bool) {
 	item := p.Item(i)
 	if action == "delete" {
-		if err := torrserver.Delete(item.Hash); err == nil {
+		if err := p.torrserver.Delete(p.Context(), item.Hash); err == nil {
 			p.Delete(i)
 		} else {
 			utils.LogError(err)
+			p.ReplyMessage(resilience.Message(err))
 		}
 	}
 	return true
@@ -71,28 +83,41 @@ func (p *TorrserverPaginator) Compare(i int, j int, attribute string) bool {
 
 func (p *TorrserverPaginator) Reload() error {
 
-	result, err := torrserver.List()
+	result, err := p.torrserver.List(p.Context())
 	if err != nil {
 		utils.LogError(err)
 		return err
 	}
 
-	p.Alloc(len(*result))
-	for i := range *result {
-		p.Append(&(*result)[i])
-	}
+	p.Locked(func() {
+		p.Alloc(len(*result))
+		for i := range *result {
+			p.Append(&(*result)[i])
+		}
+	})
 	return nil
 }
 
 // -------------------------------------------------------------------------
-func Handler(ctx context.Context, b *bot.Bot, update *models.Update) {
-	var p = NewPaginator(ctx, b, update)
-	p.SetupSorting([]paginator.Sorting{
-		{Attribute: "Size", Alias: "size", Order: 1},
-	})
-	if err := p.Reload(); err != nil {
-		p.ReplyMessage(err.Error())
-	} else {
-		p.Show()
+// NewRestorer rehydrates the list after restart, see paginator.RegisterRestorer
+func NewRestorer(ts *torrserver.Client) paginator.Restorer {
+	return func(ctx context.Context, b *bot.Bot, state *paginator.State) (*paginator.Paginator, error) {
+		p := NewPaginator(ctx, b, nil, ts)
+		if err := p.Reload(); err != nil {
+			return nil, err
+		}
+		p.Restore(state)
+		return &p.Paginator, nil
+	}
+}
+
+func NewHandler(ts *torrserver.Client) bot.HandlerFunc {
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		var p = NewPaginator(ctx, b, update, ts)
+		if err := p.Reload(); err != nil {
+			p.ReplyMessage(resilience.Message(err))
+		} else {
+			p.Show()
+		}
 	}
 }
@@ -0,0 +1,74 @@
+package torrserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram/bot"
+
+	apitorrserver "torrentino/api/torrserver"
+	"torrentino/common"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/fault"
+	"torrentino/fakes/telegram"
+	"torrentino/fakes/torrserver"
+)
+
+func newHarness(t *testing.T, ts *apitorrserver.Client) *telegram.Harness {
+	common.Settings.UsersList = []int64{1}
+	return telegram.NewHarness(t, 1, 1, bot.WithMessageTextHandler("/torrserver", bot.MatchTypeExact, NewHandler(ts)))
+}
+
+func TestTorrserverDown(t *testing.T) {
+	backends.FastRetries(t)
+	h := newHarness(t, apitorrserver.NewClient(common.HostPort{Host: "127.0.0.1", Port: 1})) // nothing listens there
+	h.Send("/torrserver")
+	m := h.Last()
+	if m.Text != "TorrServer unavailable, retrying in 30 s" {
+		t.Errorf("expected TorrServer error, got %q", m.Text)
+	}
+	if len(m.Keyboard) != 0 {
+		t.Errorf("error reply must not have keyboard, got %v", m.Buttons())
+	}
+}
+
+func TestListDelete(t *testing.T) {
+	b := backends.Start(t)
+	h := newHarness(t, b.TorrserverClient)
+	b.Torrserver.AddTorrent(torrserver.Torrent{Title: "Ubuntu", TorrentSize: 6 << 30})
+	b.Torrserver.AddTorrent(torrserver.Torrent{Title: "Debian", TorrentSize: 4 << 30})
+
+	h.Send("/torrserver")
+	m := h.Last()
+	if !strings.Contains(m.Text, "<b>1.</b> Ubuntu [6.00 GB]") || !strings.Contains(m.Text, "<b>2.</b> Debian [4.00 GB]") {
+		t.Fatalf("unexpected list %q", m.Text)
+	}
+
+	h.Press(m, "2")
+	h.Press(h.Last(), "delete")
+	if torrents := b.Torrserver.Torrents(); len(torrents) != 1 || torrents[0].Title != "Ubuntu" {
+		t.Errorf("unexpected torrents %v", torrents)
+	}
+	if m = h.Last(); strings.Contains(m.Text, "Debian") {
+		t.Errorf("deleted item is still listed in %q", m.Text)
+	}
+}
+
+func TestDeleteFailed(t *testing.T) {
+	b := backends.Start(t)
+	backends.FastRetries(t)
+	h := newHarness(t, b.TorrserverClient)
+	b.Torrserver.AddTorrent(torrserver.Torrent{Title: "Ubuntu", TorrentSize: 6 << 30})
+
+	h.Send("/torrserver")
+	h.Press(h.Last(), "1")
+	b.Torrserver.Inject(fault.Fault{Status: http.StatusBadGateway})
+	h.Press(h.Last(), "delete")
+	if m := h.Last(); !strings.Contains(m.Text, "TorrServer") || m.Keyboard != nil {
+		t.Errorf("expected the error reply, got %q", m.Text)
+	}
+	if len(b.Torrserver.Torrents()) != 1 {
+		t.Error("the torrent must stay")
+	}
+}
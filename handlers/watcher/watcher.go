@@ -0,0 +1,234 @@
+// Package watcher notifies users about the downloads they have started:
+// completion, errors and stalls are detected by polling the download clients
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
+
+	"torrentino/api/client"
+	"torrentino/common"
+	"torrentino/common/owners"
+	"torrentino/common/utils"
+)
+
+const (
+	DEFAULT_NOTIFY_INTERVAL = 30 * time.Second
+	DEFAULT_STALL_TIMEOUT   = 30 * time.Minute
+	UNSEEN_GRACE            = 5 * time.Minute // e.g. qBittorrent adds torrents asynchronously, the first poll may miss them
+)
+
+type key struct {
+	Instance string
+	Hash     string
+}
+
+type watch struct {
+	Name   string // title to show until the client knows the real name
+	UserID int64
+	Since  time.Time
+
+	status     string
+	downloaded int64
+	progressAt time.Time // the last time downloaded bytes have grown
+	stalled    bool
+	seen       bool // listed by the client at least once
+	restored   bool // from the owners store, completed before the first poll means completed before restart
+}
+
+var (
+	mu      sync.Mutex
+	watches = make(map[key]*watch)
+	now     = time.Now    // replaced in tests
+	second  = time.Second // of "notify-interval", shortened in tests
+)
+
+// Track starts watching the torrent added by the user to the instance
+func Track(instance string, hash string, name string, userID int64) {
+	if hash == "" || userID == 0 {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	t := now()
+	watches[key{instance, strings.ToLower(hash)}] = &watch{Name: name, UserID: userID, Since: t, progressAt: t}
+}
+
+// restore watches the torrents of the owners store again after restart, the ones which turn out to be
+// completed or missing at the first poll are dropped silently
+func restore(clients *client.Clients) {
+	mu.Lock()
+	defer mu.Unlock()
+	t := now()
+	for hash, owner := range owners.All() {
+		if _, ok := clients.Get(owner.Target); !ok || owner.UserID == 0 {
+			continue // torrserver or the instance is gone
+		}
+		k := key{owner.Target, hash}
+		if _, ok := watches[k]; !ok {
+			watches[k] = &watch{UserID: owner.UserID, Since: owner.Added, progressAt: t, restored: true}
+		}
+	}
+}
+
+func interval() time.Duration {
+	if value := common.Current().NotifyInterval; value > 0 {
+		return time.Duration(value) * second
+	}
+	return DEFAULT_NOTIFY_INTERVAL
+}
+
+func stallTimeout() time.Duration {
+	if value := common.Current().StallTimeout; value > 0 {
+		return time.Duration(value) * time.Minute
+	}
+	return DEFAULT_STALL_TIMEOUT
+}
+
+// Run polls the download clients until ctx is done
+func Run(ctx context.Context, b *bot.Bot, clients *client.Clients) {
+	restore(clients)
+	period := interval()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			poll(ctx, b, clients)
+			if next := interval(); next != period { // "notify-interval" is reloaded
+				period = next
+				ticker.Reset(period)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func poll(ctx context.Context, b *bot.Bot, clients *client.Clients) {
+	mu.Lock()
+	instances := make(map[string]bool)
+	for k := range watches {
+		instances[k.Instance] = true
+	}
+	mu.Unlock()
+
+	for instance := range instances {
+		c, ok := clients.Get(instance)
+		if !ok {
+			continue
+		}
+		torrents, err := c.List(ctx)
+		if err != nil {
+			utils.LogError(errors.Wrap(err, instance)) // keep watching, the client may come back
+			continue
+		}
+		byHash := make(map[string]*client.Torrent, len(torrents))
+		for i := range torrents {
+			byHash[strings.ToLower(torrents[i].Hash)] = &torrents[i]
+		}
+
+		mu.Lock()
+		var notes []note
+		for k, w := range watches {
+			if k.Instance != instance {
+				continue
+			}
+			t, ok := byHash[k.Hash]
+			if !ok {
+				if w.seen || w.restored || now().Sub(w.Since) >= UNSEEN_GRACE { // removed from the client, nothing to wait for
+					delete(watches, k)
+				}
+				continue
+			}
+			if !w.seen && w.restored && complete(t) {
+				delete(watches, k)
+				continue
+			}
+			w.seen = true
+			text, done := w.check(t)
+			if text != "" {
+				notes = append(notes, note{w.UserID, text})
+			}
+			if done {
+				delete(watches, k)
+			}
+		}
+		mu.Unlock()
+
+		for _, n := range notes {
+			send(ctx, b, n)
+		}
+	}
+}
+
+type note struct {
+	userID int64
+	text   string
+}
+
+func send(ctx context.Context, b *bot.Bot, n note) {
+	_, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:    n.userID,
+		Text:      n.text,
+		ParseMode: models.ParseModeHTML,
+	})
+	if err != nil {
+		utils.LogError(err)
+	}
+}
+
+func complete(t *client.Torrent) bool {
+	return t.PercentDone >= 1 || t.Status == client.STATUS_SEEDING || t.Status == client.STATUS_SEED_WAIT
+}
+
+// check compares the torrent with the previous poll, returns the notification text if any
+// and whether the watch is over
+func (w *watch) check(t *client.Torrent) (text string, done bool) {
+	name := t.Name
+	if name == "" {
+		name = w.Name
+	}
+	name = "<b>" + html.EscapeString(name) + "</b>"
+	defer func() { w.status = t.Status }()
+
+	if complete(t) {
+		size := t.TotalSize
+		if size == 0 {
+			size = t.DownloadedEver
+		}
+		return "✅ downloaded " + name +
+			"\nsize: " + utils.FormatFileSize(uint64(size)) +
+			"\ntook: " + now().Sub(w.Since).Round(time.Second).String() +
+			"\npath: " + html.EscapeString(t.DownloadDir), true
+	}
+
+	if t.Status == client.STATUS_ERROR {
+		if w.status != client.STATUS_ERROR {
+			return "❌ download failed " + name + "\n" + html.EscapeString(t.Error), false
+		}
+		return "", false
+	}
+
+	if t.DownloadedEver > w.downloaded || t.Status == client.STATUS_STOPPED { // paused by someone is not a stall
+		w.downloaded = t.DownloadedEver
+		w.progressAt = now()
+		w.stalled = false
+		return "", false
+	}
+	if !w.stalled && now().Sub(w.progressAt) >= stallTimeout() {
+		w.stalled = true
+		return "⚠️ download stalled " + name +
+			"\nno progress for " + now().Sub(w.progressAt).Round(time.Minute).String() +
+			" at " + fmt.Sprintf("%.0f%%", t.PercentDone*100) + " [" + t.Status + "]", false
+	}
+	return "", false
+}
@@ -0,0 +1,181 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"torrentino/common"
+	"torrentino/common/owners"
+	"torrentino/fakes/backends"
+	"torrentino/fakes/telegram"
+	"torrentino/fakes/transmission"
+)
+
+const user = 7
+
+func setup(t *testing.T) (*backends.Backends, *telegram.Harness, *time.Time) {
+	b := backends.Start(t)
+	h := telegram.NewHarness(t, user, user)
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	t.Cleanup(func() {
+		now = time.Now
+		mu.Lock()
+		clear(watches)
+		mu.Unlock()
+	})
+	return b, h, &clock
+}
+
+func TestCompletion(t *testing.T) {
+	b, h, clock := setup(t)
+	added := b.Transmission.AddTorrent(transmission.Torrent{
+		Name: "ubuntu.iso", Status: transmission.DOWNLOADING, TotalSize: 6 << 30, DownloadDir: "/downloads/series",
+	})
+	Track("transmission", added.HashString, "Ubuntu 24.04", user)
+
+	poll(h.Ctx, h.Bot, b.Clients)
+	if len(h.Messages(user)) != 0 {
+		t.Fatal("nothing to notify about yet")
+	}
+
+	*clock = clock.Add(90 * time.Minute)
+	b.Transmission.Update(added.ID, func(t *transmission.Torrent) {
+		t.Status, t.PercentDone, t.DownloadedEver = transmission.SEEDING, 1, 6<<30
+	})
+	poll(h.Ctx, h.Bot, b.Clients)
+	m := h.Last()
+	for _, s := range []string{"downloaded <b>ubuntu.iso</b>", "6.00 GB", "1h30m0s", "/downloads/series"} {
+		if !strings.Contains(m.Text, s) {
+			t.Errorf("%q is missing in %q", s, m.Text)
+		}
+	}
+
+	poll(h.Ctx, h.Bot, b.Clients)
+	if len(h.Messages(user)) != 1 {
+		t.Error("completion must be reported once")
+	}
+}
+
+func TestErrorAndStall(t *testing.T) {
+	b, h, clock := setup(t)
+	added := b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.DOWNLOADING, PercentDone: 0.4})
+	Track("transmission", added.HashString, "Ubuntu 24.04", user)
+
+	*clock = clock.Add(DEFAULT_STALL_TIMEOUT)
+	poll(h.Ctx, h.Bot, b.Clients)
+	poll(h.Ctx, h.Bot, b.Clients)
+	if messages := h.Messages(user); len(messages) != 1 || !strings.Contains(messages[0].Text, "stalled <b>ubuntu.iso</b>") {
+		t.Fatalf("expected one stall notification, got %v", messages)
+	}
+
+	b.Transmission.Update(added.ID, func(t *transmission.Torrent) { t.Error, t.ErrorString = 3, "No data found" })
+	poll(h.Ctx, h.Bot, b.Clients)
+	poll(h.Ctx, h.Bot, b.Clients)
+	if messages := h.Messages(user); len(messages) != 2 || !strings.Contains(messages[1].Text, "No data found") {
+		t.Errorf("expected one error notification, got %v", messages)
+	}
+}
+
+func TestRemovedTorrent(t *testing.T) {
+	b, h, clock := setup(t)
+	Track("transmission", "deadbeef", "Ubuntu 24.04", user)
+	poll(h.Ctx, h.Bot, b.Clients)
+	mu.Lock()
+	if len(watches) != 1 {
+		t.Error("torrent may be not listed yet right after adding")
+	}
+	mu.Unlock()
+
+	*clock = clock.Add(UNSEEN_GRACE)
+	poll(h.Ctx, h.Bot, b.Clients)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(watches) != 0 {
+		t.Error("torrent missing in the client must not be watched")
+	}
+}
+
+func TestUpperCaseHash(t *testing.T) {
+	b, h, _ := setup(t)
+	added := b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.DOWNLOADING})
+	Track("transmission", strings.ToUpper(added.HashString), "Ubuntu 24.04", user)
+
+	b.Transmission.Update(added.ID, func(t *transmission.Torrent) { t.Status, t.PercentDone = transmission.SEEDING, 1 })
+	poll(h.Ctx, h.Bot, b.Clients)
+	if messages := h.Messages(user); len(messages) != 1 || !strings.Contains(messages[0].Text, "downloaded <b>ubuntu.iso</b>") {
+		t.Errorf("expected completion, got %v", messages)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	b, h, _ := setup(t)
+	downloading := b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.DOWNLOADING})
+	done := b.Transmission.AddTorrent(transmission.Torrent{Name: "debian.iso", Status: transmission.SEEDING, PercentDone: 1})
+	owners.Record(downloading.HashString, owners.Owner{UserID: user, Target: "transmission"})
+	owners.Record(done.HashString, owners.Owner{UserID: user, Target: "transmission"})
+	owners.Record("cafebabe", owners.Owner{UserID: user, Target: "torrserver"})
+	t.Cleanup(func() {
+		for _, hash := range []string{downloading.HashString, done.HashString, "cafebabe"} {
+			owners.Forget(hash)
+		}
+	})
+
+	restore(b.Clients) // as after restart
+	poll(h.Ctx, h.Bot, b.Clients)
+	if messages := h.Messages(user); len(messages) != 0 {
+		t.Fatalf("torrents completed before restart must not be reported, got %v", messages)
+	}
+	b.Transmission.Update(downloading.ID, func(t *transmission.Torrent) { t.Status, t.PercentDone = transmission.SEEDING, 1 })
+	poll(h.Ctx, h.Bot, b.Clients)
+	if messages := h.Messages(user); len(messages) != 1 || !strings.Contains(messages[0].Text, "downloaded <b>ubuntu.iso</b>") {
+		t.Errorf("expected completion of the restored watch, got %v", messages)
+	}
+}
+
+func TestIntervalReload(t *testing.T) {
+	b, h, _ := setup(t)
+	file, dir := path.Join(t.TempDir(), common.DEFAULT_FILE), t.TempDir()
+	load := func(interval int) {
+		os.WriteFile(file, fmt.Appendf(nil, `{ "telegram-api-token" : "token", "jackett" : { "host" : "nas", "api-key" : "key" },
+			"transmission" : { "host" : "nas" }, "path" : { "default" : %q }, "notify-interval" : %d }`, dir, interval), 0o600)
+		if err := common.Load(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, unit := common.Settings, second
+	second = time.Millisecond
+	load(10)
+	added := b.Transmission.AddTorrent(transmission.Torrent{Name: "ubuntu.iso", Status: transmission.DOWNLOADING})
+	Track("transmission", added.HashString, "Ubuntu 24.04", user)
+
+	ctx, cancel := context.WithCancel(h.Ctx)
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, h.Bot, b.Clients)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		common.Settings, second = before, unit
+	})
+
+	for hits, deadline := b.Transmission.Hits(), time.Now().Add(5*time.Second); b.Transmission.Hits() < hits+2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the clients are not polled")
+		}
+	}
+	load(3_600_000)                   // an hour
+	time.Sleep(50 * time.Millisecond) // the tick in flight applies it
+	hits := b.Transmission.Hits()
+	time.Sleep(100 * time.Millisecond)
+	if polled := b.Transmission.Hits() - hits; polled != 0 {
+		t.Errorf("the reloaded interval must apply, polled %d times more", polled)
+	}
+}
@@ -2,42 +2,100 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"slices"
+	"strings"
 
+	"torrentino/api/client"
+	"torrentino/api/jackett"
+	"torrentino/api/qbittorrent"
+	apitorrserver "torrentino/api/torrserver"
+	"torrentino/api/transmission"
 	"torrentino/common"
+	"torrentino/common/auth"
+	"torrentino/common/owners"
+	"torrentino/common/paginator"
 	"torrentino/handlers/downloads"
 	"torrentino/handlers/search"
+	"torrentino/handlers/status"
 	"torrentino/handlers/torrserver"
+	"torrentino/handlers/watcher"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
 )
 
+func newDownloadClient(cfg common.DownloadClient) (client.DownloadClient, error) {
+	password, err := cfg.Secret()
+	if err != nil {
+		return nil, errors.Wrap(err, cfg.Name)
+	}
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, cfg.Name)
+	}
+	switch cfg.Type {
+	case "", "transmission":
+		return transmission.New(cfg.Endpoint(transmission.DEFAULT_PORT, transmission.DEFAULT_RPC_PATH), cfg.Username, password, tlsConfig)
+	case "qbittorrent":
+		return qbittorrent.New(cfg.Endpoint(qbittorrent.DEFAULT_PORT, "/"), cfg.Username, password, tlsConfig)
+	}
+	return nil, errors.New("unknown download client type: " + cfg.Type)
+}
+
 func main() {
+	config := flag.String("config", "", "settings file (default \""+common.DEFAULT_FILE+"\" in the working directory, optional)")
+	checkConfig := flag.Bool("check-config", false, "validate the settings and exit")
+	flag.Usage = func() {
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "\nthe settings may be overridden by environment variables (add "+common.ENV_FILE+
+			" to read the value from a file):\n  "+strings.Join(common.EnvNames(), "\n  "))
+	}
+	flag.Parse()
+
+	err := common.Load(*config)
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("settings are valid")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	log.Println("[Torrentino]: startup")
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
+	clients := client.NewClients()
+	for _, cfg := range common.Settings.Clients() {
+		c, err := newDownloadClient(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clients.Set(cfg.Name, c)
+	}
+	jkt, err := jackett.NewClient(common.Settings.Jackett)
+	if err != nil {
+		log.Fatal(err)
+	}
+	backends := search.Backends{Jackett: jkt, Torrserver: apitorrserver.NewClient(common.Settings.Torrserver), Clients: clients}
+
 	opts := []bot.Option{
 		bot.WithSkipGetMe(),
-		bot.WithMiddlewares(func(next bot.HandlerFunc) bot.HandlerFunc {
-			return func(ctx context.Context, b *bot.Bot, update *models.Update) {
-				if (update != nil) && (update.Message != nil) {
-					if slices.Index(common.Settings.UsersList, update.Message.From.ID) == -1 {
-						log.Printf("%d (%s) say: %s", update.Message.From.ID, update.Message.From.Username, update.Message.Text)
-						return
-					}
-				}
-				next(ctx, b, update)
-			}
-		}),
-		bot.WithDefaultHandler(search.Handler),
-		bot.WithMessageTextHandler("/downloads", bot.MatchTypeExact, downloads.Handler),
-		bot.WithMessageTextHandler("/torrserver", bot.MatchTypeExact, torrserver.Handler),
+		bot.WithMiddlewares(auth.Middleware),
+		bot.WithDefaultHandler(auth.Command("search", search.NewHandler(backends))),
+		bot.WithMessageTextHandler("/downloads", bot.MatchTypeExact, auth.Command("/downloads", downloads.NewHandler(clients))),
+		bot.WithMessageTextHandler("/torrserver", bot.MatchTypeExact, auth.Command("/torrserver", torrserver.NewHandler(backends.Torrserver))),
+		bot.WithMessageTextHandler("/status", bot.MatchTypeExact, auth.Command("/status", status.NewHandler(backends.Jackett, backends.Torrserver, clients))),
 	}
 
 	b, err := bot.New(common.Settings.TelegramAPIToken, opts...)
@@ -45,12 +103,49 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if common.Settings.SessionStore != "" {
+		if paginator.Storage, err = paginator.NewFileStore(common.Settings.SessionStore); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ownersStore := common.Settings.OwnersStore
+	if ownersStore == "" {
+		ownersStore = owners.DEFAULT_FILE
+	}
+	if err = owners.Open(ownersStore); err != nil {
+		log.Fatal(err)
+	}
+	paginator.RegisterRestorer(b, "find", search.NewRestorer(backends))
+	paginator.RegisterRestorer(b, "pick", search.NewPickRestorer(backends))
+	paginator.RegisterRestorer(b, "list", downloads.NewRestorer(clients))
+	paginator.RegisterRestorer(b, "files", downloads.NewFilesRestorer(clients))
+	paginator.RegisterRestorer(b, "torrserver", torrserver.NewRestorer(backends.Torrserver))
+	paginator.RegisterRestorer(b, "status", status.NewRestorer(backends.Jackett, backends.Torrserver, clients))
+
 	b.SetMyCommands(ctx, &bot.SetMyCommandsParams{
 		Commands: []models.BotCommand{
 			{Command: "/downloads", Description: "Downloads"},
 			{Command: "/torrserver", Description: "Torrserver"},
+			{Command: "/status", Description: "Status of services"},
 		},
 	})
 
+	go common.Watch(ctx, *config, func(err error, restart []string) {
+		text := "🔄 settings are reloaded"
+		switch {
+		case err != nil:
+			log.Println(err)
+			text = "⚠️ settings are not reloaded, " + err.Error()
+		case len(restart) > 0:
+			text += ", restart to apply " + strings.Join(restart, ", ")
+		}
+		for _, userID := range auth.Admins() {
+			if _, err := b.SendMessage(ctx, &bot.SendMessageParams{ChatID: userID, Text: text}); err != nil {
+				log.Println(err)
+			}
+		}
+	})
+	go paginator.Collect(ctx)
+	go watcher.Run(ctx, b, clients)
 	b.Start(ctx)
 }